@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 
 	"github.com/VKCOM/php-parser/pkg/ast"
 	"github.com/VKCOM/php-parser/pkg/visitor"
@@ -15,10 +16,28 @@ type Context struct {
 }
 
 type Vuln struct {
-	Sources []string
-	Sinks   []string
-	Args    map[string][]int
-	Filters []string
+	Sources  []string
+	Sinks    []string
+	Args     map[string][]int
+	Filters  []string
+	Gadgets  []string
+	Contexts map[string]string
+	// storage functions, writes give the key and value arguments,
+	// reads the key argument
+	Writes map[string][]int
+	Reads  map[string]int
+	// filters that only hold in some positions of a query or command,
+	// the positions come from the sink's context or the vuln's language
+	Positions map[string][]string
+	Language  string
+	CWE       int
+	Severity  string
+	Payload   string
+	// payloads for where the taint lands, by position
+	Payloads map[string]string
+	// the vuln is told apart by its sources rather than its sinks, -backward
+	// only reports it when one of them reaches the sink
+	Sourced bool
 }
 
 type Taint struct {
@@ -28,6 +47,29 @@ type Taint struct {
 	Vertex ast.Vertex
 	Parent *Taint
 	Stack  string
+	// set when the taint may be read from another file
+	Filename string
+	// filters passed that only work in some positions of the sink
+	Escaped []string
+	// reasons to be less sure about the path
+	Doubts []string
+	// where the source was read, for -surface
+	Origin ast.Vertex
+}
+
+// ReadAt is where the request was read for a taint traced at a vertex
+func (t Taint) ReadAt(at ast.Vertex) ast.Vertex {
+	if t.Vertex == nil {
+		return at
+	}
+	return t.Origin
+}
+
+// Constant is a variable known to hold a string literal
+type Constant struct {
+	Name  string
+	Value string
+	Scope Context
 }
 
 type Item struct {
@@ -35,6 +77,7 @@ type Item struct {
 	Type   string
 	Scope  Context
 	Vertex ast.Vertex
+	Key    string
 }
 
 type Analyzer struct {
@@ -42,6 +85,18 @@ type Analyzer struct {
 
 	CallStack      []Item
 	Tainted        []Taint
+	Constants      []Constant
+	ReadKeys       []string
+	Reported       bool
+	Stores         bool // stored taints, whose traces in other files show its code
+	Prepared       bool
+	Ignores        []Ignore
+	Entries        []Entry
+	XML            map[string]bool
+	UploadGuards   *Conditions
+	HostGuards     *Conditions
+	LoaderOff      *Conditions
+	Declared       map[string]bool // functions annotated as sinks or sanitizers
 	CurrentContext Context
 	Data           map[string]Vuln
 	Filename       string
@@ -50,6 +105,7 @@ type Analyzer struct {
 func NewAnalyzer(filename string, datafile string) *Analyzer {
 	var analyzer = &Analyzer{
 		Filename: filename,
+		Declared: make(map[string]bool),
 	}
 
 	analyzer.LoadData(datafile)
@@ -80,48 +136,331 @@ func (a *Analyzer) DumpStack(taint Taint) string {
 	return str
 }
 
-// Trace up to the nearest sink, assignment, or valid filter
-func (a *Analyzer) Trace(taint Taint) {
-	for _, item := range a.CallStack {
+// Trace up to the nearest sink, assignment, or valid filter,
+// at is the vertex where the taint was found
+func (a *Analyzer) Trace(taint Taint, at ast.Vertex) {
+	// whether a sink reported or skipped the taint
+	decided := false
+	for i, item := range a.CallStack {
 		switch item.Type {
 		case "filter":
+			known := false
 			for _, f := range a.Data[taint.Type].Filters {
-				if item.Name == f {
-					return
+				if item.Name != f {
+					continue
+				}
+				// judged by where the escaped value lands, here if the string
+				// around the filter tells, otherwise at the sink
+				if _, ok := a.Data[taint.Type].Positions[f]; ok {
+					known = true
+					if position := a.FilterPosition(taint, i); position != "" {
+						taint.Escaped = With(taint.Escaped, f+"@"+position)
+						a.Explain(taint, at, "escaped by %s, landing in a %s position", f, position)
+						break
+					}
+					taint.Escaped = With(taint.Escaped, f)
+					a.Explain(taint, at, "escaped by %s, judged at the sink by where it lands", f)
+					break
 				}
+				a.Touch(taint, item, at, false)
+				a.Explain(taint, at, "stopped by filter %s", f)
+				return
+			}
+			// string interpolation passes taint on by design
+			if !known && item.Name != "MAGICQUOTES" {
+				taint.Doubts = With(taint.Doubts, "unknown function "+item.Name)
+				a.Explain(taint, at, "passes through %s, not a known filter", item.Name)
+			}
+			if item.Name != "MAGICQUOTES" {
+				a.Touch(taint, item, at, true)
 			}
+		case "call":
+			// a call to a function nothing is known about, the taint goes on
+			taint.Doubts = With(taint.Doubts, "dynamic call")
+			a.Explain(taint, at, "passes through a dynamic call to an unknown function")
+			a.Touch(taint, item, at, true)
+		case "doubt":
+			taint.Doubts = With(taint.Doubts, item.Name)
+			a.Explain(taint, at, "doubt: %s", item.Name)
 		case "sink":
-			for sink, _ := range a.Data[taint.Type].Args {
+			a.Touch(taint, item, at, false)
+			if !a.InContext(taint, item, at) {
+				a.Explain(taint, at, "sink %s skipped, the %s context model does not hold here", item.Name, a.Data[taint.Type].Contexts[item.Name])
+				decided = true
+				continue
+			}
+			if a.EscapedAt(taint, item, at) {
+				a.Explain(taint, at, "sink %s skipped, escaped by %s where it lands", item.Name, strings.Join(taint.Escaped, ", "))
+				decided = true
+				continue
+			}
+			matched := false
+			for sink, args := range a.Data[taint.Type].Args {
 				if item.Name == sink {
-					// send to results when a taint meets a sink
-					Results <- Result{Vertex: item.Vertex, Type: taint.Type, LastTaint: taint, Filename: a.Filename, Stack: a.DumpStack(taint)}
+					matched = true
+					decided = true
+					if a.InArgs(item, at, args) {
+						a.Report(item, taint, at)
+					} else {
+						a.Explain(taint, at, "sink %s skipped, not in a watched argument %v", item.Name, args)
+					}
 				}
 			}
 			for _, sink := range a.Data[taint.Type].Sinks {
 				if item.Name == sink {
-					// send to results when a taint meets a sink
-					Results <- Result{Vertex: item.Vertex, Type: taint.Type, LastTaint: taint, Filename: a.Filename, Stack: a.DumpStack(taint)}
+					matched = true
+					decided = true
+					a.Report(item, taint, at)
 				}
 			}
+			if !matched {
+				a.Explain(taint, at, "passes through %s, not a sink for the type", item.Name)
+			}
 		case "assign":
-			a.AddTaint(Taint{Name: item.Name, Type: taint.Type, Scope: item.Scope, Vertex: item.Vertex, Parent: &taint, Stack: a.DumpStack(taint)})
+			doubts := taint.Doubts
+			if item.Scope.Class == "*" || item.Scope.Block == "*" {
+				doubts = With(doubts, "wildcard scope "+item.Name)
+			}
+			a.Touch(taint, item, at, true)
+			a.Explain(taint, at, "assigned to %s in %s", item.Name, scopeString(item.Scope))
+			a.AddTaint(Taint{Name: item.Name, Type: taint.Type, Scope: item.Scope, Vertex: item.Vertex, Parent: &taint, Stack: a.DumpStack(taint), Escaped: a.Place(taint, item, at), Doubts: doubts, Origin: taint.ReadAt(at)})
+			return
+		case "store":
+			a.Touch(taint, item, at, false)
+			if _, ok := a.Data[taint.Type].Writes[item.Name]; ok {
+				a.Explain(taint, at, "stored by %s as %s", item.Name, item.Key)
+				a.Stores = true
+				Stored.Store(item.Key, Taint{Name: item.Key, Type: taint.Type, Scope: Context{Class: "*", Block: "*"}, Vertex: item.Vertex, Parent: &taint, Stack: a.DumpStack(taint), Filename: a.Filename, Escaped: a.Place(taint, item, at), Doubts: taint.Doubts})
+			}
 			return
 		case "break":
+			a.Touch(taint, item, at, false)
+			a.Explain(taint, at, "stopped by %s", item.Name)
 			return
 		}
 	}
+	if !decided {
+		a.Explain(taint, at, "goes no further, nothing around it assigns, stores or filters it")
+	}
+}
+
+// InContext checks the context model a vuln has for a sink, if any
+func (a *Analyzer) InContext(taint Taint, item Item, at ast.Vertex) bool {
+	model, ok := ContextModels[a.Data[taint.Type].Contexts[item.Name]]
+	if !ok {
+		return true
+	}
+	arg, prefix := ArgPrefix(item.Vertex, at)
+	return model(SinkContext{Call: item.Vertex, Arg: arg, Prefix: prefix, XML: a.XML, Flow: a.Flow(taint, item, at), UploadGuards: a.UploadGuards, HostGuards: a.HostGuards, LoaderOff: a.LoaderOff})
+}
+
+// Flow names the variables a taint went through to a sink and the request
+// entry it was read from
+func (a *Analyzer) Flow(taint Taint, item Item, at ast.Vertex) []string {
+	var names []string
+	read := item.Vertex
+	for t := &taint; t != nil && t.Vertex != nil; t = t.Parent {
+		names = append(names, t.Name)
+		read = t.Vertex
+	}
+	// the source was read in the first assignment, or in the sink itself
+	if origin := taint.ReadAt(at); origin != nil && read != nil {
+		if key := ReadKey(read, origin); key != "" {
+			names = append(names, key)
+		}
+	}
+	return names
 }
 
-func (a *Analyzer) VarVertex(name string) {
+// Report sends a taint meeting a sink to results, unless a comment silenced it
+func (a *Analyzer) Report(item Item, taint Taint, at ast.Vertex) {
+	if a.Suppressed(taint.Type, item.Vertex) {
+		a.Explain(taint, item.Vertex, "sink %s skipped, silenced by a comment", item.Name)
+		return
+	}
+	a.Explain(taint, item.Vertex, "reported at sink %s", item.Name)
+	a.Reported = true
+	if IsDynamicCall(item.Vertex) {
+		taint.Doubts = With(taint.Doubts, "dynamic call")
+	}
+
+	vuln := a.Data[taint.Type]
+	Results <- Result{Vertex: item.Vertex, Type: taint.Type, LastTaint: taint, Filename: a.Filename, Stack: a.DumpStack(taint), CWE: vuln.CWE, Severity: vuln.Level(), Confidence: Confidence(taint.Doubts), Doubts: taint.Doubts, Payload: a.PayloadFor(taint, item, at), Entry: a.EntryFor(a.CurrentContext)}
+}
+
+// EscapedAt checks whether a filter the taint passed holds where it lands in the sink.
+// Filters applied inside a string carry the position they were applied at, the
+// others are judged by the sink's prefix, an empty one being the start of the
+// query or command
+func (a *Analyzer) EscapedAt(taint Taint, item Item, at ast.Vertex) bool {
+	if len(taint.Escaped) == 0 {
+		return false
+	}
+	classify, ok := a.PositionModel(taint, item)
+	if !ok {
+		// nothing to judge by, the filters hold as usual
+		return true
+	}
+
+	_, prefix := ArgPrefix(item.Vertex, at)
+	for _, escape := range taint.Escaped {
+		f, position := escape, ""
+		if i := strings.LastIndex(escape, "@"); i >= 0 {
+			f, position = escape[:i], escape[i+1:]
+		}
+		if position == "" {
+			position = classify(prefix)
+		}
+		for _, p := range a.Data[taint.Type].Positions[f] {
+			if p == position {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PositionModel is how positions are told apart in a sink, by the sink's
+// context or the vuln's language
+func (a *Analyzer) PositionModel(taint Taint, item Item) (func(string) string, bool) {
+	classify, ok := PositionModels[a.Data[taint.Type].Contexts[item.Name]]
+	if !ok {
+		classify, ok = PositionModels[a.Data[taint.Type].Language]
+	}
+	return classify, ok
+}
+
+// PayloadFor picks the payload for where a taint lands in a sink, from the
+// sink's prefix or the position a filter carried, or the vuln's own
+func (a *Analyzer) PayloadFor(taint Taint, item Item, at ast.Vertex) string {
+	vuln := a.Data[taint.Type]
+	classify, ok := a.PositionModel(taint, item)
+	if !ok {
+		return vuln.Payload
+	}
+
+	position := ""
+	if _, prefix := ArgPrefix(item.Vertex, at); prefix != "" {
+		position = classify(prefix)
+	} else {
+		for _, escape := range taint.Escaped {
+			if i := strings.LastIndex(escape, "@"); i >= 0 {
+				position = escape[i+1:]
+				break
+			}
+		}
+	}
+	if payload, ok := vuln.Payloads[position]; ok {
+		return payload
+	}
+	return vuln.Payload
+}
+
+// FilterPosition classifies where the filter at i of the call stack is applied,
+// from the string an assignment, store or sink around it builds, "" if unknown
+func (a *Analyzer) FilterPosition(taint Taint, i int) string {
+	classify := a.StringModel(taint.Type)
+	if classify == nil {
+		return ""
+	}
+
+	filter := a.CallStack[i].Vertex
+	for _, item := range a.CallStack[i+1:] {
+		switch item.Type {
+		case "filter":
+			// the argument of some other call, not the string itself
+			if item.Name != "MAGICQUOTES" {
+				return ""
+			}
+		case "call":
+			return ""
+		case "assign", "store", "sink":
+			if prefix := HolderPrefix(item.Vertex, filter); prefix != "" {
+				return classify(prefix)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// StringModel is how positions are told apart in the strings a vuln builds,
+// by its language or the model its sinks share, nil if there is none
+func (a *Analyzer) StringModel(typ string) func(string) string {
+	if classify, ok := PositionModels[a.Data[typ].Language]; ok {
+		return classify
+	}
+	// commands have no language
+	var classify func(string) string
+	for _, model := range a.Data[typ].Contexts {
+		if _, ok := PositionModels[model]; !ok {
+			return nil
+		}
+		classify = PositionModels[model]
+	}
+	return classify
+}
+
+// Place gives the filters a taint passed without a position the one it lands
+// at in the string an assignment or store builds, so that a query or command
+// put together on the way is judged where the escaped value went
+func (a *Analyzer) Place(taint Taint, item Item, at ast.Vertex) []string {
+	classify := a.StringModel(taint.Type)
+	prefix := HolderPrefix(item.Vertex, at)
+	if classify == nil || prefix == "" {
+		return taint.Escaped
+	}
+
+	position := classify(prefix)
+	var escaped []string
+	for _, escape := range taint.Escaped {
+		if !strings.Contains(escape, "@") {
+			a.Explain(taint, at, "escaped by %s, landing in a %s position", escape, position)
+			escape += "@" + position
+		}
+		escaped = append(escaped, escape)
+	}
+	return escaped
+}
+
+// InArgs checks that the taint sits in one of the watched arguments
+func (a *Analyzer) InArgs(item Item, at ast.Vertex, args []int) bool {
+	arg, _ := ArgPrefix(item.Vertex, at)
+	for _, i := range args {
+		if i == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Analyzer) VarVertex(name string, n ast.Vertex) {
 	for _, taint := range a.Tainted {
 		if taint.Name == name {
 			if a.CompareContexts(taint.Scope, a.CurrentContext) {
-				a.Trace(taint)
+				a.Trace(taint, n)
+			} else {
+				a.Explain(taint, n, "not traced, the taint is scoped to %s", scopeString(taint.Scope))
 			}
 		}
 	}
 }
 
+// Root gathers what the file says about itself, only on the first pass over the tree
+func (a *Analyzer) Root(n *ast.Root) {
+	if a.Prepared {
+		return
+	}
+	a.Prepared = true
+
+	a.ReadAnnotations(n)
+	a.Entries = FindEntries(n)
+	a.XML = FindXML(n)
+	a.UploadGuards = FindUploadChecks(n)
+	a.HostGuards = FindConditions(n, HostChecks)
+	a.LoaderOff = FindLoaderOff(n)
+}
+
 // search for taints to track
 
 func (a *Analyzer) ExprVariable(n *ast.ExprVariable) {
@@ -131,7 +470,7 @@ func (a *Analyzer) ExprVariable(n *ast.ExprVariable) {
 	}
 	name := string(id.Value)
 
-	a.VarVertex(name)
+	a.VarVertex(name, n)
 }
 
 func (a *Analyzer) ExprPropertyFetch(n *ast.ExprPropertyFetch) {
@@ -141,7 +480,7 @@ func (a *Analyzer) ExprPropertyFetch(n *ast.ExprPropertyFetch) {
 	}
 	name := string(id.Value)
 
-	a.VarVertex(name)
+	a.VarVertex(name, n)
 }
 
 func (a *Analyzer) ExprFunctionCall(n *ast.ExprFunctionCall) {
@@ -153,29 +492,74 @@ func (a *Analyzer) ExprFunctionCall(n *ast.ExprFunctionCall) {
 	for _, v := range funcName.Parts {
 		name += string(v.(*ast.NamePart).Value)
 	}
+	a.VarVertex(name, n)
+	a.StorageRead(name, n)
+}
 
-	a.VarVertex(name)
+// StorageRead traces data read back from storage that a tainted write put there
+func (a *Analyzer) StorageRead(name string, n ast.Vertex) {
+	for t, vuln := range a.Data {
+		i, ok := vuln.Reads[name]
+		if !ok {
+			continue
+		}
+		if key, ok := StorageArg(name, n, i); ok {
+			a.LoadStored(t, name, StorageKey(name, key), n)
+		}
+	}
 }
 
-func (a *Analyzer) ExprMethodCall(n *ast.ExprMethodCall) {
-	obj, ok := n.Var.(*ast.ExprVariable)
-	if !ok {
-		return
+// LoadStored traces the taints of a type stored under a key from where it is read
+func (a *Analyzer) LoadStored(t string, name string, key string, n ast.Vertex) {
+	a.ReadKeys = appendUnique(a.ReadKeys, key)
+
+	// the read's own call is not on the way out of it
+	if stack := a.CallStack; len(stack) > 0 && stack[0].Vertex == n {
+		a.CallStack = stack[1:]
+		defer func() { a.CallStack = stack }()
+	}
+	for _, stored := range Stored.Load(key) {
+		if stored.Type != t {
+			continue
+		}
+		stored := stored
+		a.Trace(Taint{Name: key, Type: t, Scope: a.CurrentContext, Vertex: n, Parent: &stored, Stack: "[stored] " + name + " <- [taint] " + key, Doubts: stored.Doubts}, n)
 	}
-	cid, ok := obj.Name.(*ast.Identifier)
+}
+
+// $_SESSION['key'] reads what an earlier request stored there
+func (a *Analyzer) ExprArrayDimFetch(n *ast.ExprArrayDimFetch) {
+	key, ok := SessionKey(n)
 	if !ok {
 		return
 	}
+	for t, vuln := range a.Data {
+		if _, ok := vuln.Reads["$_SESSION"]; ok {
+			a.LoadStored(t, "$_SESSION", StorageKey("$_SESSION", key), n)
+		}
+	}
+}
+
+func (a *Analyzer) ExprMethodCall(n *ast.ExprMethodCall) {
 	mid, ok := n.Method.(*ast.Identifier)
 	if !ok {
 		return
 	}
-
 	methodname := string(mid.Value)
-	fullname := string(cid.Value) + "->" + methodname
 
-	a.VarVertex(fullname)
-	a.VarVertex(methodname)
+	// (new Foo)->bar() has no object name to qualify with
+	if obj, ok := n.Var.(*ast.ExprVariable); ok {
+		if cid, ok := obj.Name.(*ast.Identifier); ok {
+			a.VarVertex(string(cid.Value)+"->"+methodname, n)
+		}
+	}
+
+	a.VarVertex(methodname, n)
+
+	// $wpdb->get_var() and the like read the tables others insert into
+	if inList(TableFunctions, methodname) {
+		a.StorageRead(methodname, n)
+	}
 }
 
 // auxiliary funcs
@@ -191,8 +575,47 @@ func (a *Analyzer) AddTaint(add Taint) {
 	a.Tainted = append(a.Tainted, add)
 }
 
+func (a *Analyzer) AddConstant(add Constant) {
+	for _, c := range a.Constants {
+		if c.Name == add.Name && c.Value == add.Value && a.CompareContexts(c.Scope, add.Scope) {
+			return
+		}
+	}
+
+	a.Constants = append(a.Constants, add)
+}
+
+// ResolveCallee returns the function names a callee expression may hold
+func (a *Analyzer) ResolveCallee(n ast.Vertex) []string {
+	var names []string
+	switch callee := n.(type) {
+	case *ast.ScalarString:
+		names = append(names, Unquote(string(callee.Value)))
+	case *ast.ExprVariable:
+		id, ok := callee.Name.(*ast.Identifier)
+		if !ok {
+			return nil
+		}
+		for _, c := range a.Constants {
+			if c.Name == string(id.Value) && a.CompareContexts(c.Scope, a.CurrentContext) {
+				names = append(names, c.Value)
+			}
+		}
+	case *ast.ExprBrackets:
+		return a.ResolveCallee(callee.Expr)
+	}
+	return names
+}
+
+func Unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
 func (a *Analyzer) CompareTaints(t1 Taint, t2 Taint) bool {
-	return t1.Name == t2.Name && t1.Type == t2.Type && a.CompareContexts(t1.Scope, t2.Scope)
+	return t1.Name == t2.Name && t1.Type == t2.Type && a.CompareContexts(t1.Scope, t2.Scope) && strings.Join(t1.Escaped, ",") == strings.Join(t2.Escaped, ",")
 }
 
 func (a *Analyzer) CompareContexts(c1 Context, c2 Context) bool {
@@ -211,6 +634,10 @@ func (a *Analyzer) LoadData(filename string) {
 		panic(err)
 	}
 
+	if !SurfaceMode {
+		delete(a.Data, "surface")
+	}
+
 	// add sources to taint list
 	for t, vuln := range a.Data {
 		for _, source := range vuln.Sources {
@@ -0,0 +1,341 @@
+package main
+
+import "testing"
+
+func TestEscapedAt(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		typ    string
+		line   int
+		want   bool
+	}{
+		{
+			"query built in a variable, escaped inside quotes",
+			"<?php\n$sql = \"SELECT * FROM t WHERE a='\" . esc_sql($_GET['a']) . \"'\";\n$wpdb->get_results($sql);",
+			"sqli", 3, false,
+		},
+		{
+			"query built in a variable, escaped outside quotes",
+			"<?php\n$sql = \"SELECT * FROM t WHERE a=\" . esc_sql($_GET['a']);\n$wpdb->get_results($sql);",
+			"sqli", 3, true,
+		},
+		{
+			"escaped value interpolated inside quotes",
+			"<?php\n$a = esc_sql($_GET['a']);\n$wpdb->get_results(\"SELECT * FROM t WHERE a='$a'\");",
+			"sqli", 3, false,
+		},
+		{
+			"escaped value interpolated outside quotes",
+			"<?php\n$a = esc_sql($_GET['a']);\n$wpdb->get_results(\"SELECT * FROM t WHERE a=$a\");",
+			"sqli", 3, true,
+		},
+		{
+			"escaped where the query is unknown",
+			"<?php\n$a = esc_sql($_GET['a']);\n$wpdb->get_results($a);",
+			"sqli", 3, true,
+		},
+		{
+			"escaped value concatenated into a query outside quotes",
+			"<?php\n$id = esc_sql($_GET['id']);\n$q = \"SELECT * FROM t WHERE id = \" . $id;\n$wpdb->get_results($q);",
+			"sqli", 4, true,
+		},
+		{
+			"escaped value interpolated into a query outside quotes",
+			"<?php\n$id = esc_sql($_GET['id']);\n$q = \"SELECT * FROM t WHERE id = $id\";\n$wpdb->get_results($q);",
+			"sqli", 4, true,
+		},
+		{
+			"escaped value concatenated into a query inside quotes",
+			"<?php\n$id = esc_sql($_GET['id']);\n$q = \"SELECT * FROM t WHERE id = '\" . $id . \"'\";\n$wpdb->get_results($q);",
+			"sqli", 4, false,
+		},
+		{
+			"command built in a variable, escaped as an argument",
+			"<?php\n$cmd = \"ls -la \" . escapeshellarg($_GET['x']);\nsystem($cmd);",
+			"rce", 3, false,
+		},
+		{
+			"command built in a variable, escaped inside single quotes",
+			"<?php\n$cmd = \"ls -la '\" . escapeshellarg($_GET['x']) . \"'\";\nsystem($cmd);",
+			"rce", 3, true,
+		},
+		{
+			"escaped argument interpolated into a command",
+			"<?php\n$x = escapeshellarg($_GET['x']);\nsystem(\"ls -la $x\");",
+			"rce", 3, false,
+		},
+		{
+			"escaped argument concatenated into single quotes",
+			"<?php\n$x = escapeshellarg($_GET['x']);\n$cmd = \"ls '\" . $x . \"'\";\nsystem($cmd);",
+			"rce", 4, true,
+		},
+		{
+			"escaped argument concatenated as an argument",
+			"<?php\n$x = escapeshellarg($_GET['x']);\n$cmd = \"ls \" . $x;\nsystem($cmd);",
+			"rce", 4, false,
+		},
+		{
+			"escaped argument run as the command",
+			"<?php\nsystem(escapeshellarg($_GET['x']));",
+			"rce", 2, true,
+		},
+		{
+			"whole command escaped, arguments can still be added",
+			"<?php\n$cmd = escapeshellcmd(\"ls \" . $_GET['x']);\nsystem($cmd);",
+			"rce", 3, true,
+		},
+	}
+	for _, test := range tests {
+		if _, got := reported(analyze(t, test.source), test.typ, test.line); got != test.want {
+			t.Errorf("%s: reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInjectionClasses(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		typ    string
+		line   int
+		want   bool
+	}{
+		{
+			"header built from the request",
+			"<?php\nheader('X-Name: ' . $_GET['n']);",
+			"header-injection", 2, true,
+		},
+		{
+			"header built from a sanitized value",
+			"<?php\nheader('X-Name: ' . sanitize_text_field($_GET['n']));",
+			"header-injection", 2, false,
+		},
+		{
+			"cookie named by the request",
+			"<?php\nsetcookie($_GET['n'], 'v');",
+			"header-injection", 2, true,
+		},
+		{
+			"cookie value, urlencoded by php",
+			"<?php\nsetcookie('n', $_GET['v']);",
+			"header-injection", 2, false,
+		},
+		{
+			"mail headers from the request",
+			"<?php\nmail($to, $subject, $body, 'From: ' . $_POST['from']);",
+			"mail-injection", 2, true,
+		},
+		{
+			"mail headers from a sanitized address",
+			"<?php\nmail($to, $subject, $body, 'From: ' . sanitize_email($_POST['from']));",
+			"mail-injection", 2, false,
+		},
+		{
+			"mail recipient from the request",
+			"<?php\nmail($_POST['to'], $subject, $body);",
+			"mail-injection", 2, false,
+		},
+		{
+			"wp_mail headers from the request",
+			"<?php\nwp_mail($to, $subject, $body, $_POST['headers']);",
+			"mail-injection", 2, true,
+		},
+		{
+			"log line from the request",
+			"<?php\nerror_log('login failed for ' . $_POST['user']);",
+			"log-injection", 2, true,
+		},
+		{
+			"log line from an encoded value",
+			"<?php\nerror_log('login failed for ' . json_encode($_POST['user']));",
+			"log-injection", 2, false,
+		},
+		{
+			"syslog message from the request",
+			"<?php\nsyslog(LOG_WARNING, $_GET['msg']);",
+			"log-injection", 2, true,
+		},
+		{
+			"syslog priority from the request",
+			"<?php\nsyslog($_GET['level'], 'message');",
+			"log-injection", 2, false,
+		},
+	}
+	for _, test := range tests {
+		if _, got := reported(analyze(t, test.source), test.typ, test.line); got != test.want {
+			t.Errorf("%s: reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestContextModels(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		typ    string
+		line   int
+		want   bool
+	}{
+		{
+			"query on a database object",
+			"<?php\n$pdo->query($_GET['a']);",
+			"xpath-injection", 2, false,
+		},
+		{
+			"query on a DOMXPath object",
+			"<?php\n$xp = new DOMXPath($doc);\n$xp->query(\"//user[name='\" . $_GET['a'] . \"']\");",
+			"xpath-injection", 3, true,
+		},
+		{
+			"xpath on a SimpleXMLElement property",
+			"<?php\nclass A { function f() {\n$this->xml = simplexml_load_string($s);\n$this->xml->xpath($_GET['a']);\n} }",
+			"xpath-injection", 4, true,
+		},
+		{
+			"xml parsed with entities substituted",
+			"<?php\n$doc->loadXML($_POST['x'], LIBXML_NOENT);",
+			"xxe", 2, true,
+		},
+		{
+			"xml parsed without entities substituted",
+			"<?php\n$doc->loadXML($_POST['x']);",
+			"xxe", 2, false,
+		},
+		{
+			"entity loader disabled before parsing",
+			"<?php\nlibxml_disable_entity_loader(true);\n$doc->loadXML($_POST['x'], LIBXML_NOENT);",
+			"xxe", 3, false,
+		},
+		{
+			"entity loader disabled after parsing",
+			"<?php\n$doc->loadXML($_POST['x'], LIBXML_NOENT);\nlibxml_disable_entity_loader(true);",
+			"xxe", 2, true,
+		},
+		{
+			"entity loader enabled before parsing",
+			"<?php\nlibxml_disable_entity_loader(false);\n$doc->loadXML($_POST['x'], LIBXML_NOENT);",
+			"xxe", 3, true,
+		},
+		{
+			"entity loader disabled in another function",
+			"<?php\nfunction off() { libxml_disable_entity_loader(); }\nfunction parse() { $doc->loadXML($_POST['x'], LIBXML_NOENT); }",
+			"xxe", 3, true,
+		},
+		{
+			"file operation on a path the request gives",
+			"<?php\nif (file_exists($_GET['f'])) {}",
+			"object-injection", 2, true,
+		},
+		{
+			"file operation on a path under a directory",
+			"<?php\nif (file_exists('uploads/' . $_GET['f'])) {}",
+			"object-injection", 2, false,
+		},
+		{
+			"redirect to a path",
+			"<?php\nwp_redirect('/wp-admin/' . $_GET['page']);",
+			"open-redirect", 2, false,
+		},
+		{
+			"redirect to a path glued to a slash",
+			"<?php\nwp_redirect('/' . $_GET['to']);",
+			"open-redirect", 2, true,
+		},
+		{
+			"redirect with its host allow-listed",
+			"<?php\n$url = $_GET['to'];\n$host = parse_url($url, PHP_URL_HOST);\nif (in_array($host, $allowed)) {\nwp_redirect($url);\n}",
+			"open-redirect", 5, false,
+		},
+		{
+			"redirect with something else allow-listed",
+			"<?php\n$url = $_GET['to'];\nif (in_array($_GET['lang'], $langs)) {\nwp_redirect($url);\n}",
+			"open-redirect", 4, true,
+		},
+		{
+			"redirect after a failed allow-list check leaves",
+			"<?php\n$url = $_GET['to'];\nif (!in_array(parse_url($url, PHP_URL_HOST), $allowed)) {\nexit;\n}\nwp_redirect($url);",
+			"open-redirect", 6, false,
+		},
+		{
+			"redirect after a deny-list check",
+			"<?php\n$url = $_GET['to'];\n$host = parse_url($url, PHP_URL_HOST);\nif (in_array($host, $blocked)) {\ndie();\n}\nwp_redirect($url);",
+			"open-redirect", 7, true,
+		},
+		{
+			"redirect with the host checked in another function",
+			"<?php\nfunction allowed($url) {\nif (in_array(parse_url($url, PHP_URL_HOST), $hosts)) {\nreturn true;\n}\n}\n$url = $_GET['to'];\nwp_redirect($url);",
+			"open-redirect", 8, true,
+		},
+		{
+			"redirect with the host checked after it",
+			"<?php\n$url = $_GET['to'];\nwp_redirect($url);\nif (!in_array($url, $allowed)) {\nexit;\n}",
+			"open-redirect", 3, true,
+		},
+		{
+			"redirect with a url built from it allow-listed",
+			"<?php\n$to = $_GET['to'];\n$url = $to . $path;\nif (in_array($url, $allowed)) {\nwp_redirect($to);\n}",
+			"open-redirect", 5, true,
+		},
+		{
+			"upload with its extension read but never compared",
+			"<?php\n$name = $_FILES['f']['name'];\n$ext = pathinfo($name, PATHINFO_EXTENSION);\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $name);",
+			"unrestricted-upload", 4, true,
+		},
+		{
+			"upload with its file checked and the result dropped",
+			"<?php\ngetimagesize($_FILES['f']['tmp_name']);\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $_FILES['f']['name']);",
+			"unrestricted-upload", 3, true,
+		},
+		{
+			"upload with its extension allow-listed",
+			"<?php\n$name = $_FILES['f']['name'];\n$ext = pathinfo($name, PATHINFO_EXTENSION);\nif (!in_array($ext, $allowed)) {\ndie();\n}\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $name);",
+			"unrestricted-upload", 7, false,
+		},
+		{
+			"upload with its extension compared",
+			"<?php\n$name = $_FILES['f']['name'];\n$ext = pathinfo($name, PATHINFO_EXTENSION);\nif ($ext === 'jpg') {\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $name);\n}",
+			"unrestricted-upload", 5, false,
+		},
+		{
+			"upload with a wrong extension turned away",
+			"<?php\n$type = wp_check_filetype($_FILES['f']['name']);\nif ($type['ext'] != 'png') {\nreturn;\n}\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $_FILES['f']['name']);",
+			"unrestricted-upload", 6, false,
+		},
+		{
+			"upload with its name matched",
+			"<?php\n$name = $_FILES['f']['name'];\nif (!preg_match('/\\.jpe?g$/', $name)) {\nexit;\n}\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $name);",
+			"unrestricted-upload", 6, false,
+		},
+		{
+			"upload moved once its file is an image",
+			"<?php\nif (getimagesize($_FILES['f']['tmp_name'])) {\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $_FILES['f']['name']);\n}",
+			"unrestricted-upload", 3, false,
+		},
+		{
+			"upload checked only after it is moved",
+			"<?php\n$name = $_FILES['f']['name'];\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $name);\nif (!in_array(pathinfo($name, PATHINFO_EXTENSION), $allowed)) {\nexit;\n}",
+			"unrestricted-upload", 3, true,
+		},
+		{
+			"upload with something else checked",
+			"<?php\n$info = pathinfo($_GET['x']);\ngetimagesize($_FILES['g']['tmp_name']);\nmove_uploaded_file($_FILES['f']['tmp_name'], 'up/' . $_FILES['f']['name']);",
+			"unrestricted-upload", 4, true,
+		},
+		{
+			"file opened for writing",
+			"<?php\n$fp = fopen($_GET['p'], 'w');\nfwrite($fp, 'x');",
+			"file-write", 2, true,
+		},
+		{
+			"file opened for reading",
+			"<?php\n$fp = fopen($_GET['p'], 'r');",
+			"file-write", 2, false,
+		},
+	}
+	for _, test := range tests {
+		if _, got := reported(analyze(t, test.source), test.typ, test.line); got != test.want {
+			t.Errorf("%s: reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
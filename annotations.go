@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/VKCOM/noverify/src/php/parseutil"
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/token"
+)
+
+var (
+	ignoreComment = regexp.MustCompile(`php-analyzer-ignore(\[([\w\-, ]*)\])?`)
+	docAnnotation = regexp.MustCompile(`@php-analyzer-(sanitizes|source|sink)\b([\w\-, \t]*)`)
+)
+
+// Ignore silences findings of some types, or all when Types is empty, between two lines
+type Ignore struct {
+	Types     []string
+	StartLine int
+	EndLine   int
+}
+
+func (i Ignore) Covers(t string, line int) bool {
+	if line < i.StartLine || line > i.EndLine {
+		return false
+	}
+	if len(i.Types) == 0 {
+		return true
+	}
+	for _, it := range i.Types {
+		if it == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Annotation is what a docblock declares a function to be, for some types or
+// all when Types is empty
+type Annotation struct {
+	Kind     string // sanitizes, source or sink
+	Function string
+	Types    []string
+}
+
+// Annotated holds the annotations of every file of the scan, read before it
+// starts since a function annotated in one file is called from the others
+var Annotated []Annotation
+
+// ProjectAnnotations reads the docblock annotations of the files, only
+// parsing those that have any
+func ProjectAnnotations(names []string) []Annotation {
+	var annotations []Annotation
+	for _, name := range names {
+		content, err := readFile(name)
+		if err != nil || !HasAnnotations(content) {
+			continue
+		}
+		root, err := parseutil.ParseFile(content)
+		if err != nil || root == nil {
+			continue
+		}
+		_, found := readComments(root)
+		annotations = append(annotations, found...)
+	}
+	return annotations
+}
+
+// HasAnnotations tells the files that may declare annotations without parsing them
+func HasAnnotations(content []byte) bool {
+	return bytes.Contains(content, []byte("@php-analyzer-"))
+}
+
+// ReadAnnotations reads suppression comments and docblock annotations, and
+// applies those the other files of the scan declare
+func (a *Analyzer) ReadAnnotations(n *ast.Root) {
+	ignores, annotations := readComments(n)
+	a.Ignores = append(a.Ignores, ignores...)
+	for _, annotation := range append(annotations, Annotated...) {
+		a.Annotate(annotation)
+	}
+}
+
+// readComments finds the ignore comments of a file and the annotations of
+// the functions and methods it declares
+func readComments(n *ast.Root) ([]Ignore, []Annotation) {
+	var (
+		comments    []*token.Token
+		stmts       []ast.Vertex
+		ignores     []Ignore
+		annotations []Annotation
+	)
+	walkTokens(reflect.ValueOf(n), func(t *token.Token) {
+		for _, ff := range t.FreeFloating {
+			if ff.ID == token.T_COMMENT || ff.ID == token.T_DOC_COMMENT {
+				comments = append(comments, ff)
+			}
+		}
+	}, func(v ast.Vertex) {
+		if strings.HasPrefix(reflect.TypeOf(v).Elem().Name(), "Stmt") && v.GetPosition() != nil {
+			stmts = append(stmts, v)
+		}
+	})
+
+	for _, c := range comments {
+		if c.Position == nil {
+			continue
+		}
+		text := string(c.Value)
+
+		if m := ignoreComment.FindStringSubmatch(text); m != nil {
+			ignore := Ignore{Types: splitTypes(m[2]), StartLine: c.Position.StartLine, EndLine: c.Position.EndLine}
+			// a comment after code on the same line only covers that line,
+			// otherwise it covers the statement or block that follows
+			if !trailing(c, stmts) {
+				if stmt := following(c, stmts); stmt != nil {
+					ignore.EndLine = stmt.GetPosition().EndLine
+				}
+			}
+			ignores = append(ignores, ignore)
+		}
+
+		if c.ID == token.T_DOC_COMMENT {
+			annotations = append(annotations, docAnnotations(text, following(c, stmts))...)
+		}
+	}
+	return ignores, annotations
+}
+
+// docAnnotations reads the annotations of a docblock on the function or
+// method it documents
+func docAnnotations(doc string, stmt ast.Vertex) []Annotation {
+	var name ast.Vertex
+	switch function := stmt.(type) {
+	case *ast.StmtFunction:
+		name = function.Name
+	case *ast.StmtClassMethod:
+		name = function.Name
+	default:
+		return nil
+	}
+	id, ok := name.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+
+	var annotations []Annotation
+	for _, m := range docAnnotation.FindAllStringSubmatch(doc, -1) {
+		annotations = append(annotations, Annotation{Kind: m[1], Function: string(id.Value), Types: splitTypes(m[2])})
+	}
+	return annotations
+}
+
+// Annotate applies an annotation to the types it names, once
+func (a *Analyzer) Annotate(annotation Annotation) {
+	fn := annotation.Function
+	types := annotation.Types
+	if len(types) == 0 {
+		for t := range a.Data {
+			types = append(types, t)
+		}
+	}
+
+	for _, t := range types {
+		vuln, ok := a.Data[t]
+		if !ok {
+			continue
+		}
+		switch annotation.Kind {
+		case "sanitizes":
+			if !inList(vuln.Filters, fn) {
+				vuln.Filters = append(vuln.Filters, fn)
+			}
+			a.Declared[fn] = true
+		case "sink":
+			if !inList(vuln.Sinks, fn) {
+				vuln.Sinks = append(vuln.Sinks, fn)
+			}
+			a.Declared[fn] = true
+		case "source":
+			a.AddTaint(Taint{Name: fn, Type: t, Scope: Context{Class: "*", Block: "*"}})
+		}
+		a.Data[t] = vuln
+	}
+}
+
+// Suppressed checks for an ignore comment covering a finding
+func (a *Analyzer) Suppressed(t string, n ast.Vertex) bool {
+	pos := n.GetPosition()
+	if pos == nil {
+		return false
+	}
+	for _, ignore := range a.Ignores {
+		if ignore.Covers(t, pos.StartLine) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitTypes(str string) []string {
+	var types []string
+	for _, t := range strings.FieldsFunc(str, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		types = append(types, t)
+	}
+	return types
+}
+
+// following finds the outermost statement starting after a comment
+func following(c *token.Token, stmts []ast.Vertex) ast.Vertex {
+	var best ast.Vertex
+	for _, stmt := range stmts {
+		pos := stmt.GetPosition()
+		if pos.StartPos < c.Position.EndPos {
+			continue
+		}
+		if best == nil || pos.StartPos < best.GetPosition().StartPos ||
+			(pos.StartPos == best.GetPosition().StartPos && pos.EndPos > best.GetPosition().EndPos) {
+			best = stmt
+		}
+	}
+	return best
+}
+
+// trailing reports whether a comment shares its line with the end of a statement before it
+func trailing(c *token.Token, stmts []ast.Vertex) bool {
+	for _, stmt := range stmts {
+		pos := stmt.GetPosition()
+		if pos.EndLine == c.Position.StartLine && pos.EndPos <= c.Position.StartPos {
+			return true
+		}
+	}
+	return false
+}
+
+// walkTokens visits every token and vertex reachable from v,
+// tokens are only exposed as struct fields so this goes by reflection
+func walkTokens(v reflect.Value, tkn func(*token.Token), vertex func(ast.Vertex)) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			walkTokens(v.Elem(), tkn, vertex)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		switch n := v.Interface().(type) {
+		case *token.Token:
+			tkn(n)
+			return
+		case ast.Vertex:
+			vertex(n)
+		}
+		walkTokens(v.Elem(), tkn, vertex)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			walkTokens(v.Field(i), tkn, vertex)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			walkTokens(v.Index(i), tkn, vertex)
+		}
+	}
+}
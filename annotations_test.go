@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreComments(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		typ    string
+		line   int
+		want   bool
+	}{
+		{
+			"comment above the sink",
+			"<?php\n// php-analyzer-ignore\necho $_GET['a'];",
+			"xss", 3, false,
+		},
+		{
+			"comment after the sink",
+			"<?php\necho $_GET['a']; // php-analyzer-ignore\necho $_GET['b'];",
+			"xss", 2, false,
+		},
+		{
+			"comment after the sink leaves the next line",
+			"<?php\necho $_GET['a']; // php-analyzer-ignore\necho $_GET['b'];",
+			"xss", 3, true,
+		},
+		{
+			"comment above a block",
+			"<?php\n/* php-analyzer-ignore */\nif ($a) {\necho $_GET['a'];\n}",
+			"xss", 4, false,
+		},
+		{
+			"comment in a method",
+			"<?php\nclass A {\nfunction f() {\n# php-analyzer-ignore\necho $_GET['a'];\n}\n}\n(new A)->f();",
+			"xss", 5, false,
+		},
+		{
+			"comment for the type",
+			"<?php\n// php-analyzer-ignore[xss]\necho $_GET['a'];",
+			"xss", 3, false,
+		},
+		{
+			"comment for another type",
+			"<?php\n// php-analyzer-ignore[sqli, rce]\necho $_GET['a'];",
+			"xss", 3, true,
+		},
+		{
+			"comment on an unrelated line",
+			"<?php\n$a = 1; // php-analyzer-ignore\necho $_GET['a'];",
+			"xss", 3, true,
+		},
+		{
+			"comment without the marker",
+			"<?php\n// php-analyzer is noisy here\necho $_GET['a'];",
+			"xss", 3, true,
+		},
+	}
+	for _, test := range tests {
+		if _, got := reported(analyze(t, test.source), test.typ, test.line); got != test.want {
+			t.Errorf("%s: reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDocAnnotations(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		typ    string
+		line   int
+		want   bool
+	}{
+		{
+			"sanitizer",
+			"<?php\n/** @php-analyzer-sanitizes xss */\nfunction clean($s) { return $s; }\necho clean($_GET['a']);",
+			"xss", 4, false,
+		},
+		{
+			"sanitizer of another type",
+			"<?php\n/** @php-analyzer-sanitizes sqli */\nfunction clean($s) { return $s; }\necho clean($_GET['a']);",
+			"xss", 4, true,
+		},
+		{
+			"sink",
+			"<?php\n/**\n * @php-analyzer-sink rce\n */\nfunction run($c) {}\nrun($_GET['a']);",
+			"rce", 6, true,
+		},
+		{
+			"sink method",
+			"<?php\nclass A {\n/** @php-analyzer-sink rce */\nfunction run($c) {}\n}\n$a->run($_GET['a']);",
+			"rce", 6, true,
+		},
+		{
+			"source",
+			"<?php\n/** @php-analyzer-source xss */\nfunction input() {}\necho input();",
+			"xss", 4, true,
+		},
+		{
+			"function without the annotation",
+			"<?php\n/** Reads the input */\nfunction input() {}\necho input();",
+			"xss", 4, false,
+		},
+	}
+	for _, test := range tests {
+		if _, got := reported(analyze(t, test.source), test.typ, test.line); got != test.want {
+			t.Errorf("%s: reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProjectAnnotations(t *testing.T) {
+	tests := []struct {
+		name    string
+		helpers string
+		caller  string
+		typ     string
+		want    bool
+	}{
+		{
+			"sanitizer declared in another file",
+			"<?php\n/** @php-analyzer-sanitizes xss */\nfunction helper($s) { return $s; }",
+			"<?php\necho helper($_GET['a']);",
+			"xss", false,
+		},
+		{
+			"function of another file without the annotation",
+			"<?php\nfunction helper($s) { return $s; }",
+			"<?php\necho helper($_GET['a']);",
+			"xss", true,
+		},
+		{
+			"sink declared in another file",
+			"<?php\n/** @php-analyzer-sink rce */\nfunction run($c) {}",
+			"<?php\nrun($_GET['a']);",
+			"rce", true,
+		},
+		{
+			"source declared in another file",
+			"<?php\n/** @php-analyzer-source xss */\nfunction input() {}",
+			"<?php\necho input();",
+			"xss", true,
+		},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		helpers, caller := filepath.Join(dir, "helpers.php"), filepath.Join(dir, "caller.php")
+		if err := os.WriteFile(helpers, []byte(test.helpers), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(caller, []byte(test.caller), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// the caller is scanned first, before the file declaring what it calls
+		findings := scan([]string{caller, helpers}, 10, 1, "data.yaml", false, Output{MinSeverity: "info", MinConfidence: "low"})
+		if got := hasFinding(findings, test.typ, 2); got != test.want {
+			t.Errorf("%s: reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// InMemory holds files read from a git revision or an archive instead of
+// disk, by the name they are scanned as
+var InMemory = &MemoryFiles{files: make(map[string][]byte), archives: make(map[string]bool)}
+
+type MemoryFiles struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+	// archives read, whose files are named archive/path
+	archives map[string]bool
+}
+
+// Add keeps a file, false if one of that name is already kept
+func (m *MemoryFiles) Add(name string, content []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		return false
+	}
+	m.files[name] = content
+	return true
+}
+
+// Release drops a file that won't be read again
+func (m *MemoryFiles) Release(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.files, name)
+}
+
+// Keep drops every file but those named, what an earlier scan left behind.
+// Archives stay known, their files are still reported by their path in them
+func (m *MemoryFiles) Keep(names []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+	for name := range m.files {
+		if !keep[name] {
+			delete(m.files, name)
+		}
+	}
+}
+
+// AddArchive keeps the files of an archive, named by the archive and their path in it
+func (m *MemoryFiles) AddArchive(archive string, files []ArchiveFile) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.archives[archive] = true
+	var names []string
+	for _, f := range files {
+		name := archive + "/" + f.Name
+		m.files[name] = f.Content
+		names = append(names, name)
+	}
+	return names
+}
+
+// Archive splits the name of a file read from an archive into the archive
+// and the path from its root, an empty archive for other files
+func (m *MemoryFiles) Archive(name string) (string, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for archive := range m.archives {
+		if strings.HasPrefix(name, archive+"/") {
+			return archive, name[len(archive)+1:]
+		}
+	}
+	return "", name
+}
+
+func (m *MemoryFiles) Get(name string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	content, ok := m.files[name]
+	return content, ok
+}
+
+// IsArchive checks whether a name read from stdin is an archive to scan the
+// PHP files of
+func IsArchive(name string) bool {
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveFile is a PHP file read out of an archive
+type ArchiveFile struct {
+	Name    string
+	Content []byte
+}
+
+// Unpack reads the PHP files out of a zip, tar or gzipped tar, named by their
+// path from the archive root
+func Unpack(name string) ([]ArchiveFile, error) {
+	content, err := readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return unzip(content)
+	case strings.HasSuffix(lower, ".tar"):
+		return untar(bytes.NewReader(content))
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return untar(gz)
+}
+
+func unzip(content []byte) ([]ArchiveFile, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	var files []ArchiveFile
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.HasSuffix(f.Name, ".php") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := readEntry(rc, f.Name)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if content != nil {
+			files = append(files, ArchiveFile{Name: archivePath(f.Name), Content: content})
+		}
+	}
+	return files, nil
+}
+
+func untar(r io.Reader) ([]ArchiveFile, error) {
+	var files []ArchiveFile
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".php") {
+			continue
+		}
+		content, err := readEntry(tr, header.Name)
+		if err != nil {
+			return nil, err
+		}
+		if content != nil {
+			files = append(files, ArchiveFile{Name: archivePath(header.Name), Content: content})
+		}
+	}
+}
+
+// MaxEntrySize is the most read of a file in an archive, no PHP file comes
+// near it and an archive built to unpack into more is skipped past
+const MaxEntrySize = 16 << 20
+
+// readEntry reads a file of an archive, nil if it is larger than MaxEntrySize
+func readEntry(r io.Reader, name string) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, MaxEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxEntrySize {
+		log.Println("skipping", name, "larger than", MaxEntrySize, "bytes")
+		return nil, nil
+	}
+	return content, nil
+}
+
+// archivePath cleans up the ./ some tools put in front of entries
+func archivePath(name string) string {
+	return strings.TrimPrefix(name, "./")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var archiveEntries = []struct {
+	name    string
+	content string
+}{
+	{"./plugin.php", "<?php echo 1;"},
+	{"includes/admin.php", "<?php echo 2;"},
+	{"readme.txt", "not scanned"},
+	{"assets/app.js", "not scanned"},
+}
+
+func writeZip(t *testing.T, path string) {
+	b := &bytes.Buffer{}
+	zw := zip.NewWriter(b)
+	if _, err := zw.Create("includes/"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range archiveEntries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.content))
+	}
+	zw.Close()
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, path string) {
+	b := &bytes.Buffer{}
+	gz := gzip.NewWriter(b)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "includes/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, e := range archiveEntries {
+		tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.content))})
+		tw.Write([]byte(e.content))
+	}
+	tw.Close()
+	gz.Close()
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	dir := t.TempDir()
+	archives := map[string]func(*testing.T, string){
+		"plugin.zip":    writeZip,
+		"plugin.tar.gz": writeTarGz,
+	}
+	for name, write := range archives {
+		path := filepath.Join(dir, name)
+		write(t, path)
+
+		files, err := Unpack(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := map[string]string{}
+		for _, f := range files {
+			got[f.Name] = string(f.Content)
+		}
+		want := map[string]string{"plugin.php": "<?php echo 1;", "includes/admin.php": "<?php echo 2;"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: unpacked %v, want %v", name, got, want)
+		}
+
+		for _, scanned := range inputNames(path) {
+			archive, file := InMemory.Archive(scanned)
+			if archive != path || (file != "plugin.php" && file != "includes/admin.php") {
+				t.Errorf("%s: %s is reported as %s in %s", name, scanned, file, archive)
+			}
+		}
+	}
+}
+
+func TestUnpackTooLarge(t *testing.T) {
+	b := &bytes.Buffer{}
+	zw := zip.NewWriter(b)
+	w, _ := zw.Create("bomb.php")
+	w.Write(make([]byte, MaxEntrySize+1))
+	w, _ = zw.Create("small.php")
+	w.Write([]byte("<?php"))
+	zw.Close()
+
+	files, err := unzip(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "small.php" {
+		t.Errorf("unpacked %d files, want only small.php", len(files))
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+)
+
+// BackwardMode starts at sinks and walks back to their inputs, for -backward
+var BackwardMode bool
+
+// Input is what a sink argument is built from that the file does not control
+type Input struct {
+	Kind  string // source, parameter, property or global
+	Name  string
+	Steps []Hop // assignments from the input to the sink, outermost last
+}
+
+// Hop is an assignment an input passed through
+type Hop struct {
+	Name   string
+	Vertex ast.Vertex
+}
+
+var inputOrder = map[string]int{"source": 0, "parameter": 1, "property": 2, "global": 3}
+
+// scope is a function, method or closure body, or the top level of the file
+type scope struct {
+	vertex  ast.Vertex
+	class   string
+	block   string
+	top     bool
+	params  map[string]bool
+	globals map[string]bool
+	assigns map[string][]assign
+}
+
+type assign struct {
+	vertex ast.Vertex
+	expr   ast.Vertex
+}
+
+type sinkCall struct {
+	vertex ast.Vertex
+	name   string
+	all    []ast.Vertex
+}
+
+// SinkFinder visitor takes an inventory of sink calls and of the assignments,
+// parameters and globals of every scope, then walks back from each sink
+type SinkFinder struct {
+	visitor.Null
+	a       *Analyzer
+	classes []ast.Vertex
+	scopes  []*scope
+	sinks   []sinkCall
+}
+
+func NewSinkFinder(a *Analyzer) *SinkFinder {
+	return &SinkFinder{a: a}
+}
+
+// Find reports every sink whose arguments depend on an input
+func (sf *SinkFinder) Find(root *ast.Root) {
+	sf.a.Root(root)
+	sf.scopes = append(sf.scopes, newScope(root, "", "", true))
+	root.Accept(traverser.NewTraverser(sf))
+
+	// in a fixed order, a sink shared by several vulns is reported for each
+	var types []string
+	for t := range sf.a.Data {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, call := range sf.sinks {
+		s := sf.scopeOf(call.vertex)
+		for _, t := range types {
+			vuln := sf.a.Data[t]
+			args := call.all
+			if indices, ok := vuln.Args[call.name]; ok {
+				args = nil
+				for _, i := range indices {
+					if i < len(call.all) {
+						args = append(args, call.all[i])
+					}
+				}
+			} else if !inList(vuln.Sinks, call.name) {
+				continue
+			}
+			if len(args) == 0 || sf.a.Suppressed(t, call.vertex) ||
+				!sf.a.InContext(Taint{Type: t}, Item{Name: call.name, Vertex: call.vertex}, args[0]) {
+				continue
+			}
+
+			w := &walker{sf: sf, scope: s, vuln: vuln, seen: map[string]bool{}}
+			for _, arg := range args {
+				w.expr(arg, nil)
+			}
+			if len(w.inputs) == 0 {
+				continue
+			}
+			sort.SliceStable(w.inputs, func(i, j int) bool {
+				return inputOrder[w.inputs[i].Kind] < inputOrder[w.inputs[j].Kind]
+			})
+			if vuln.Sourced && w.inputs[0].Kind != "source" {
+				continue
+			}
+			sf.report(t, call, s, w.inputs[0], w.doubts)
+		}
+	}
+}
+
+// report sends a sink as a result, the trace rebuilt as the forward engine would have it
+func (sf *SinkFinder) report(t string, call sinkCall, s *scope, input Input, doubts []string) {
+	label := "[" + input.Kind + "] " + input.Name
+	taint := Taint{Name: input.Name, Type: t}
+	if input.Kind != "source" {
+		doubts = With(doubts, input.Kind+" "+input.Name)
+	}
+	for i, step := range input.Steps {
+		from := label
+		if i > 0 {
+			from = "[taint] " + input.Steps[i-1].Name
+		}
+		parent := taint
+		taint = Taint{Name: step.Name, Type: t, Vertex: step.Vertex, Parent: &parent, Stack: "[assign] " + step.Name + " <- " + from}
+	}
+	stack := "[sink] " + call.name + " <- " + label
+	if len(input.Steps) > 0 {
+		stack = "[sink] " + call.name + " <- [taint] " + taint.Name
+	}
+
+	vuln := sf.a.Data[t]
+	sf.a.Reported = true
+	Results <- Result{Vertex: call.vertex, Type: t, LastTaint: taint, Filename: sf.a.Filename, Stack: stack, CWE: vuln.CWE, Severity: vuln.Level(), Confidence: Confidence(doubts), Doubts: doubts, Payload: vuln.Payload, Entry: sf.a.EntryFor(Context{Class: s.class, Block: s.block})}
+}
+
+func newScope(n ast.Vertex, class string, block string, top bool) *scope {
+	return &scope{vertex: n, class: class, block: block, top: top, params: map[string]bool{}, globals: map[string]bool{}, assigns: map[string][]assign{}}
+}
+
+// scopeOf finds the innermost scope holding a vertex, scopes are found
+// outermost first so the last one that holds it wins
+func (sf *SinkFinder) scopeOf(n ast.Vertex) *scope {
+	best := sf.scopes[0]
+	for _, s := range sf.scopes[1:] {
+		if Contains(s.vertex, n) {
+			best = s
+		}
+	}
+	return best
+}
+
+func (sf *SinkFinder) function(n ast.Vertex, name ast.Vertex, params []ast.Vertex, uses []ast.Vertex) {
+	if n.GetPosition() == nil {
+		return
+	}
+	class := ""
+	for _, c := range sf.classes {
+		if Contains(c, n) {
+			switch c := c.(type) {
+			case *ast.StmtClass:
+				class = NameString(c.Name)
+			case *ast.StmtTrait:
+				class = NameString(c.Name)
+			}
+		}
+	}
+	s := newScope(n, class, NameString(name), false)
+	for _, p := range append(params, uses...) {
+		switch p := p.(type) {
+		case *ast.Parameter:
+			s.params[NameString(varName(p.Var))] = true
+		case *ast.ExprClosureUse:
+			s.params[NameString(varName(p.Var))] = true
+		}
+	}
+	sf.scopes = append(sf.scopes, s)
+}
+
+func (sf *SinkFinder) StmtClass(n *ast.StmtClass) {
+	sf.classes = append(sf.classes, n)
+}
+
+func (sf *SinkFinder) StmtTrait(n *ast.StmtTrait) {
+	sf.classes = append(sf.classes, n)
+}
+
+func (sf *SinkFinder) StmtFunction(n *ast.StmtFunction) {
+	sf.function(n, n.Name, n.Params, nil)
+}
+
+func (sf *SinkFinder) StmtClassMethod(n *ast.StmtClassMethod) {
+	sf.function(n, n.Name, n.Params, nil)
+}
+
+func (sf *SinkFinder) ExprClosure(n *ast.ExprClosure) {
+	sf.function(n, nil, n.Params, n.Uses)
+}
+
+func (sf *SinkFinder) ExprArrowFunction(n *ast.ExprArrowFunction) {
+	sf.function(n, nil, n.Params, nil)
+}
+
+func (sf *SinkFinder) StmtGlobal(n *ast.StmtGlobal) {
+	s := sf.scopeOf(n)
+	for _, v := range n.Vars {
+		s.globals[NameString(varName(v))] = true
+	}
+}
+
+// assignments, a write to an element or property of a variable taints the variable
+func (sf *SinkFinder) assign(n ast.Vertex, target ast.Vertex, expr ast.Vertex) {
+	if v := varName(target); v != nil {
+		s := sf.scopeOf(n)
+		name := NameString(v)
+		s.assigns[name] = append(s.assigns[name], assign{vertex: n, expr: expr})
+	}
+}
+
+func (sf *SinkFinder) ExprAssign(n *ast.ExprAssign)                   { sf.assign(n, n.Var, n.Expr) }
+func (sf *SinkFinder) ExprAssignReference(n *ast.ExprAssignReference) { sf.assign(n, n.Var, n.Expr) }
+func (sf *SinkFinder) ExprAssignConcat(n *ast.ExprAssignConcat)       { sf.assign(n, n.Var, n.Expr) }
+func (sf *SinkFinder) ExprAssignCoalesce(n *ast.ExprAssignCoalesce) {
+	sf.assign(n, n.Var, n.Expr)
+}
+
+func (sf *SinkFinder) StmtForeach(n *ast.StmtForeach) {
+	sf.assign(n, n.Var, n.Expr)
+	if n.Key != nil {
+		sf.assign(n, n.Key, n.Expr)
+	}
+}
+
+// sink calls, args are every argument and are narrowed per vuln
+func (sf *SinkFinder) sink(n ast.Vertex, name string, args []ast.Vertex) bool {
+	for _, vuln := range sf.a.Data {
+		if _, ok := vuln.Args[name]; ok || inList(vuln.Sinks, name) {
+			sf.sinks = append(sf.sinks, sinkCall{vertex: n, name: name, all: args})
+			return true
+		}
+	}
+	return false
+}
+
+func (sf *SinkFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	sf.sink(n, NameString(n.Function), CallArgs(n))
+}
+
+// $wpdb->query is looked up before query
+func (sf *SinkFinder) ExprMethodCall(n *ast.ExprMethodCall) {
+	if obj, ok := n.Var.(*ast.ExprVariable); ok && sf.sink(n, NameString(obj.Name)+"->"+NameString(n.Method), CallArgs(n)) {
+		return
+	}
+	sf.sink(n, NameString(n.Method), CallArgs(n))
+}
+
+func (sf *SinkFinder) ExprStaticCall(n *ast.ExprStaticCall) {
+	sf.sink(n, NameString(n.Call), CallArgs(n))
+}
+
+func (sf *SinkFinder) StmtEcho(n *ast.StmtEcho)   { sf.sink(n, "echo", n.Exprs) }
+func (sf *SinkFinder) ExprPrint(n *ast.ExprPrint) { sf.sink(n, "print", []ast.Vertex{n.Expr}) }
+func (sf *SinkFinder) ExprEval(n *ast.ExprEval)   { sf.sink(n, "eval", []ast.Vertex{n.Expr}) }
+func (sf *SinkFinder) ExprInclude(n *ast.ExprInclude) {
+	sf.sink(n, "include", []ast.Vertex{n.Expr})
+}
+func (sf *SinkFinder) ExprIncludeOnce(n *ast.ExprIncludeOnce) {
+	sf.sink(n, "include_once", []ast.Vertex{n.Expr})
+}
+func (sf *SinkFinder) ExprRequire(n *ast.ExprRequire) {
+	sf.sink(n, "require", []ast.Vertex{n.Expr})
+}
+func (sf *SinkFinder) ExprRequireOnce(n *ast.ExprRequireOnce) {
+	sf.sink(n, "require_once", []ast.Vertex{n.Expr})
+}
+func (sf *SinkFinder) ExprShellExec(n *ast.ExprShellExec) {
+	sf.sink(n, "shell_exec", n.Parts)
+}
+func (sf *SinkFinder) ExprExit(n *ast.ExprExit) {
+	if n.Expr != nil {
+		sf.sink(n, string(n.ExitTkn.Value), []ast.Vertex{n.Expr})
+	}
+}
+
+// varName is the variable at the base of $a, $a['k'] or $a->b
+func varName(n ast.Vertex) ast.Vertex {
+	for {
+		switch v := n.(type) {
+		case *ast.ExprVariable:
+			return v.Name
+		case *ast.ExprArrayDimFetch:
+			n = v.Var
+		case *ast.ExprPropertyFetch:
+			if NameString(varName(v.Var)) == "$this" {
+				return nil
+			}
+			n = v.Var
+		default:
+			return nil
+		}
+	}
+}
+
+// walker follows the data dependencies of sink arguments back to inputs
+type walker struct {
+	sf     *SinkFinder
+	scope  *scope
+	vuln   Vuln
+	seen   map[string]bool
+	inputs []Input
+	doubts []string
+}
+
+// expr collects the inputs an expression depends on, steps are the assignments taken to reach it
+func (w *walker) expr(n ast.Vertex, steps []Hop) {
+	if n == nil {
+		return
+	}
+	lf := &leafFinder{vuln: w.vuln}
+	n.Accept(traverser.NewTraverser(lf))
+
+	for _, name := range lf.unknown {
+		if !inList(w.doubts, "unknown function "+name) {
+			w.doubts = With(w.doubts, "unknown function "+name)
+		}
+	}
+	for _, leaf := range lf.leaves {
+		if lf.filtered(leaf) {
+			continue
+		}
+		switch leaf := leaf.(type) {
+		case *ast.ExprVariable:
+			w.variable(NameString(leaf.Name), steps)
+		case *ast.ExprPropertyFetch:
+			w.inputs = append(w.inputs, Input{Kind: "property", Name: "$this->" + NameString(leaf.Prop), Steps: steps})
+		case *ast.ExprStaticPropertyFetch:
+			w.inputs = append(w.inputs, Input{Kind: "property", Name: NameString(leaf.Class) + "::" + NameString(leaf.Prop), Steps: steps})
+		case *ast.ExprFunctionCall:
+			w.inputs = append(w.inputs, Input{Kind: "source", Name: NameString(leaf.Function), Steps: steps})
+		case *ast.ExprMethodCall:
+			w.inputs = append(w.inputs, Input{Kind: "source", Name: NameString(leaf.Method), Steps: steps})
+		}
+	}
+}
+
+func (w *walker) variable(name string, steps []Hop) {
+	if name == "" || name == "$this" || w.seen[name] {
+		return
+	}
+	w.seen[name] = true
+
+	switch {
+	case inList(w.vuln.Sources, name):
+		w.inputs = append(w.inputs, Input{Kind: "source", Name: name, Steps: steps})
+		return
+	case strings.HasPrefix(name, "$_"):
+		// superglobals of other vulns, PHP sets them rather than the including code
+		return
+	case name == "$GLOBALS" || w.scope.globals[name]:
+		w.inputs = append(w.inputs, Input{Kind: "global", Name: name, Steps: steps})
+		return
+	case w.scope.params[name]:
+		w.inputs = append(w.inputs, Input{Kind: "parameter", Name: name, Steps: steps})
+	}
+
+	assigns := w.scope.assigns[name]
+	// variables the file reads at the top level without setting come from the including code
+	if len(assigns) == 0 && w.scope.top {
+		w.inputs = append(w.inputs, Input{Kind: "global", Name: name, Steps: steps})
+	}
+	for _, as := range assigns {
+		w.expr(as.expr, append([]Hop{{Name: name, Vertex: as.vertex}}, steps...))
+	}
+}
+
+// leafFinder visitor collects the variables, $this properties and source calls
+// of an expression, and the calls whose arguments are sanitized for a vuln
+type leafFinder struct {
+	visitor.Null
+	vuln     Vuln
+	leaves   []ast.Vertex
+	filters  []ast.Vertex
+	unknown  []string
+	excluded []ast.Vertex
+}
+
+func (lf *leafFinder) filtered(n ast.Vertex) bool {
+	for _, f := range append(lf.filters, lf.excluded...) {
+		if f != n && Contains(f, n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (lf *leafFinder) call(n ast.Vertex, name string) {
+	if n.GetPosition() == nil {
+		return
+	}
+	_, positional := lf.vuln.Positions[name]
+	switch {
+	case inList(lf.vuln.Filters, name) && !positional:
+		lf.filters = append(lf.filters, n)
+	case inList(lf.vuln.Sources, name):
+		lf.leaves = append(lf.leaves, n)
+	case name != "" && name[0] != '(':
+		lf.unknown = append(lf.unknown, name)
+	}
+}
+
+func (lf *leafFinder) ExprVariable(n *ast.ExprVariable) {
+	if n.GetPosition() != nil {
+		lf.leaves = append(lf.leaves, n)
+	}
+}
+
+func (lf *leafFinder) ExprPropertyFetch(n *ast.ExprPropertyFetch) {
+	if v, ok := n.Var.(*ast.ExprVariable); ok && NameString(v.Name) == "$this" && n.GetPosition() != nil {
+		lf.leaves = append(lf.leaves, n)
+	}
+}
+
+func (lf *leafFinder) ExprStaticPropertyFetch(n *ast.ExprStaticPropertyFetch) {
+	if n.GetPosition() != nil {
+		lf.leaves = append(lf.leaves, n)
+		lf.excluded = append(lf.excluded, n)
+	}
+}
+
+func (lf *leafFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	lf.call(n, NameString(n.Function))
+}
+
+func (lf *leafFinder) ExprMethodCall(n *ast.ExprMethodCall) {
+	lf.call(n, NameString(n.Method))
+}
+
+func (lf *leafFinder) ExprStaticCall(n *ast.ExprStaticCall) {
+	lf.call(n, NameString(n.Call))
+}
+
+// casts are filters by the name the traverser gives them
+func (lf *leafFinder) ExprCastInt(n *ast.ExprCastInt)       { lf.call(n, "(int)") }
+func (lf *leafFinder) ExprCastBool(n *ast.ExprCastBool)     { lf.call(n, "(bool)") }
+func (lf *leafFinder) ExprCastDouble(n *ast.ExprCastDouble) { lf.call(n, "(double)") }
+func (lf *leafFinder) ExprIsset(n *ast.ExprIsset)           { lf.filters = append(lf.filters, n) }
+func (lf *leafFinder) ExprEmpty(n *ast.ExprEmpty)           { lf.filters = append(lf.filters, n) }
@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+// traceBack runs -backward over a PHP source on its own
+func traceBack(t *testing.T, source string) []Result {
+	t.Helper()
+	root := parse(t, source)
+	BackwardMode = true
+	defer func() { BackwardMode = false }()
+	return collect(func() {
+		NewSinkFinder(NewAnalyzer("test.php", "data.yaml")).Find(root)
+	})
+}
+
+func TestBackward(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		typ    string
+		line   int
+		stack  string
+	}{
+		{
+			"parameter",
+			"<?php\nfunction run($cmd) {\nsystem($cmd);\n}",
+			"rce", 3, "[sink] system <- [parameter] $cmd",
+		},
+		{
+			"parameter through an assignment",
+			"<?php\nfunction run($cmd) {\n$line = 'ls ' . $cmd;\nsystem($line);\n}",
+			"rce", 4, "[sink] system <- [taint] $line",
+		},
+		{
+			"property",
+			"<?php\nclass A {\nfunction run() {\nsystem($this->cmd);\n}\n}",
+			"rce", 4, "[sink] system <- [property] $this->cmd",
+		},
+		{
+			"global",
+			"<?php\nfunction run() {\nglobal $cmd;\nsystem($cmd);\n}",
+			"rce", 4, "[sink] system <- [global] $cmd",
+		},
+		{
+			"literal",
+			"<?php\nfunction run() {\n$cmd = 'ls';\nsystem($cmd);\n}",
+			"rce", 4, "",
+		},
+		{
+			"parameter of a type told apart by its sources",
+			"<?php\nfunction show($s) {\necho $s;\n}",
+			"csrf", 3, "",
+		},
+		{
+			"parameter of a type that isn't",
+			"<?php\nfunction show($s) {\necho $s;\n}",
+			"xss", 3, "[sink] echo <- [parameter] $s",
+		},
+		{
+			"source of a type told apart by its sources",
+			"<?php\necho $_POST['a'];",
+			"csrf", 2, "[sink] echo <- [source] $_POST",
+		},
+	}
+	for _, test := range tests {
+		r, ok := reported(traceBack(t, test.source), test.typ, test.line)
+		if test.stack == "" {
+			if ok {
+				t.Errorf("%s: reported %s, want nothing", test.name, r.Stack)
+			}
+			continue
+		}
+		if !ok || r.Stack != test.stack {
+			t.Errorf("%s: reported %q, want %q", test.name, r.Stack, test.stack)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/VKCOM/noverify/src/php/parseutil"
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+)
+
+// Summary is what a scan of one file leaves behind for the next: its findings,
+// the storage keys it writes and reads, which are how the taint of one file
+// reaches another, the functions it declares and calls, and the annotations
+// it declares, which apply in every file
+type Summary struct {
+	Findings    []Finding
+	Writes      []string
+	Reads       []string
+	Functions   []FunctionSummary
+	Calls       []string
+	Annotations []Annotation
+}
+
+// FunctionSummary is a function or method a file declares, by a hash of its
+// code and docblock, whose annotations apply where it is called
+type FunctionSummary struct {
+	Name string
+	Hash string
+}
+
+// Cache keeps a summary per file on disk, keyed by a hash of the file and of
+// everything else the findings depend on, so scans only analyze files that
+// changed, the files sharing storage keys with them and the files calling a
+// function that changed
+type Cache struct {
+	Dir string
+	// hash of the analyzer, the data file and the options findings depend on
+	Rules string
+	// the key each file had last time, to find what it used to write
+	index map[string]string
+}
+
+func NewCache(dir string, datafile string, options ...interface{}) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(datafile)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	// another build of the analyzer may find other things
+	fmt.Fprintf(h, "%s\x00", BuildID())
+	h.Write(data)
+	fmt.Fprintf(h, "%v", options)
+
+	c := &Cache{Dir: dir, Rules: hex.EncodeToString(h.Sum(nil)), index: map[string]string{}}
+	c.load("index", &c.index)
+	return c, nil
+}
+
+// BuildID names the analyzer's build, its version and the commit it was built
+// from, when the build recorded one
+func BuildID() string {
+	id := Version
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" || setting.Key == "vcs.modified" {
+				id += " " + setting.Value
+			}
+		}
+	}
+	return id
+}
+
+func (c *Cache) load(name string, v interface{}) bool {
+	content, err := os.ReadFile(filepath.Join(c.Dir, name))
+	if err != nil {
+		return false
+	}
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(v); err != nil {
+		log.Println("cache:", name, err)
+		return false
+	}
+	return true
+}
+
+func (c *Cache) save(name string, v interface{}) {
+	b := &bytes.Buffer{}
+	err := gob.NewEncoder(b).Encode(v)
+	if err == nil {
+		// written aside first so an interrupted scan leaves no half entry
+		tmp := filepath.Join(c.Dir, name+".tmp")
+		if err = os.WriteFile(tmp, b.Bytes(), 0644); err == nil {
+			err = os.Rename(tmp, filepath.Join(c.Dir, name))
+		}
+	}
+	if err != nil {
+		log.Println("cache:", name, err)
+	}
+}
+
+// drop deletes a summary no file has any longer
+func (c *Cache) drop(key string) {
+	if err := os.Remove(filepath.Join(c.Dir, key)); err != nil && !os.IsNotExist(err) {
+		log.Println("cache:", key, err)
+	}
+}
+
+// Key names the summary of a file under the cache's rules, by its name too
+// since findings name their file
+func (c *Cache) Key(name string, content []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.Rules + "\x00" + name + "\x00"))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Scan analyzes the files whose summaries are missing or depend on them, and
+// returns the findings of every file, from the cache where it could
+func (c *Cache) Scan(names []string, depth int, threads int, datafile string, gadgets bool, out Output) []Finding {
+	data := NewAnalyzer("", datafile).Data
+
+	keys := map[string]string{}
+	summaries := map[string]*Summary{}
+	dirty := map[string]bool{}
+	wrote := map[string][]string{}
+	declared := map[string][]FunctionSummary{}
+	for _, name := range names {
+		content, err := readFile(name)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		keys[name] = c.Key(name, content)
+		s := &Summary{}
+		loaded := c.load(keys[name], s)
+		summaries[name] = s
+		if loaded && c.index[name] == keys[name] {
+			continue
+		}
+
+		// what a changed file writes, reads and used to write, before it is analyzed.
+		// A summary of the same content from an earlier scan is no use on its own,
+		// the files it shares storage with may have changed since
+		dirty[name] = true
+		if !loaded {
+			*s = summarize(content, data)
+		}
+		if old := (&Summary{}); c.index[name] != "" && c.load(c.index[name], old) {
+			wrote[name] = old.Writes
+			declared[name] = old.Functions
+		}
+	}
+
+	// files gone since last time no longer write what they did, only files in
+	// the directories scanned are known to be gone rather than left out
+	scanned := map[string]bool{}
+	for _, name := range names {
+		scanned[filepath.Dir(name)] = true
+	}
+	for name, key := range c.index {
+		if _, ok := keys[name]; ok || !under(name, scanned) {
+			continue
+		}
+		if old := (&Summary{}); c.load(key, old) {
+			dirty[name] = true
+			wrote[name] = old.Writes
+			declared[name] = old.Functions
+			summaries[name] = &Summary{}
+		}
+		delete(c.index, name)
+		c.drop(key)
+	}
+
+	// a file is analyzed again when it reads what an analyzed file writes, and so
+	// are the files writing what it reads, for their taint to be stored again,
+	// and the files calling a function a changed file declared or declares
+	writers, readers, callers := map[string][]string{}, map[string][]string{}, map[string][]string{}
+	for name, s := range summaries {
+		for _, k := range append(s.Writes, wrote[name]...) {
+			writers[k] = append(writers[k], name)
+		}
+		for _, k := range s.Reads {
+			readers[k] = append(readers[k], name)
+		}
+		for _, fn := range s.Calls {
+			callers[fn] = append(callers[fn], name)
+		}
+	}
+	analyze := map[string]bool{}
+	var pending []string
+	for name := range dirty {
+		pending = append(pending, name)
+	}
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if analyze[name] {
+			continue
+		}
+		analyze[name] = true
+		for _, k := range append(summaries[name].Writes, wrote[name]...) {
+			pending = append(pending, readers[k]...)
+		}
+		for _, k := range summaries[name].Reads {
+			pending = append(pending, writers[k]...)
+		}
+		if dirty[name] {
+			for _, fn := range changedFunctions(declared[name], summaries[name].Functions) {
+				pending = append(pending, callers[fn]...)
+			}
+		}
+	}
+
+	var queue []string
+	var findings []Finding
+	for _, name := range names {
+		if analyze[name] && keys[name] != "" {
+			queue = append(queue, name)
+		} else if s, ok := summaries[name]; ok {
+			findings = append(findings, s.Findings...)
+		}
+	}
+	log.Printf("%d files from the cache, %d to analyze", len(keys)-len(queue), len(queue))
+	if len(queue) == 0 {
+		c.save("index", c.index)
+		return findings
+	}
+
+	// the files left out still annotate what the others call
+	var annotations []Annotation
+	for _, name := range names {
+		if s, ok := summaries[name]; ok {
+			annotations = append(annotations, s.Annotations...)
+		}
+	}
+	byFile := map[string][]Finding{}
+	for _, f := range scanPart(queue, annotations, depth, threads, datafile, gadgets, out) {
+		// archived files are reported by their path in the archive
+		name := f.Path[len(f.Path)-1].Filename
+		byFile[name] = append(byFile[name], f)
+		findings = append(findings, f)
+	}
+	for _, name := range queue {
+		s := summaries[name]
+		s.Findings = byFile[name]
+		c.save(keys[name], s)
+		if old := c.index[name]; old != "" && old != keys[name] {
+			c.drop(old)
+		}
+		c.index[name] = keys[name]
+	}
+	c.save("index", c.index)
+	return findings
+}
+
+// under reports whether a file is in one of the directories or below it
+func under(name string, dirs map[string]bool) bool {
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		if dirs[dir] {
+			return true
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return false
+		}
+	}
+}
+
+// summarize reads the storage keys a file writes and reads, the functions it
+// declares and calls and its annotations, nothing if it can't be parsed
+func summarize(content []byte, data map[string]Vuln) Summary {
+	root, err := parseutil.ParseFile(content)
+	if err != nil || root == nil {
+		return Summary{}
+	}
+	kf := &keyFinder{data: data}
+	root.Accept(traverser.NewTraverser(kf))
+	ff := &functionFinder{content: content, calls: map[string]bool{}}
+	root.Accept(traverser.NewTraverser(ff))
+
+	s := Summary{Writes: kf.writes, Reads: kf.reads, Functions: ff.functions}
+	if HasAnnotations(content) {
+		_, s.Annotations = readComments(root)
+	}
+	for fn := range ff.calls {
+		s.Calls = append(s.Calls, fn)
+	}
+	sort.Strings(s.Calls)
+	return s
+}
+
+// changedFunctions names the functions declared before or after that differ,
+// methods of the same name in several classes are compared together
+func changedFunctions(before []FunctionSummary, after []FunctionSummary) []string {
+	hashes := func(functions []FunctionSummary) map[string]string {
+		m := map[string]string{}
+		for _, f := range functions {
+			m[f.Name] += f.Hash
+		}
+		return m
+	}
+	old, new := hashes(before), hashes(after)
+
+	var changed []string
+	for name, hash := range old {
+		if new[name] != hash {
+			changed = append(changed, name)
+		}
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// functionFinder visitor collects the functions and methods a file declares,
+// hashed with the docblock in front of them, and the names it calls, in lower
+// case as PHP matches them
+type functionFinder struct {
+	visitor.Null
+	content   []byte
+	functions []FunctionSummary
+	calls     map[string]bool
+}
+
+func (ff *functionFinder) declare(n ast.Vertex, name ast.Vertex) {
+	pos := n.GetPosition()
+	if pos == nil || pos.EndPos > len(ff.content) {
+		return
+	}
+	h := sha256.New()
+	h.Write([]byte(docBefore(ff.content, pos.StartPos)))
+	h.Write(ff.content[pos.StartPos:pos.EndPos])
+	ff.functions = append(ff.functions, FunctionSummary{Name: strings.ToLower(NameString(name)), Hash: hex.EncodeToString(h.Sum(nil))})
+}
+
+// docBefore is the docblock right in front of an offset, if any
+func docBefore(content []byte, offset int) string {
+	before := bytes.TrimRight(content[:offset], " \t\r\n")
+	if !bytes.HasSuffix(before, []byte("*/")) {
+		return ""
+	}
+	if i := bytes.LastIndex(before, []byte("/**")); i >= 0 {
+		return string(before[i:])
+	}
+	return ""
+}
+
+func (ff *functionFinder) StmtFunction(n *ast.StmtFunction) {
+	ff.declare(n, n.Name)
+}
+
+func (ff *functionFinder) StmtClassMethod(n *ast.StmtClassMethod) {
+	ff.declare(n, n.Name)
+}
+
+func (ff *functionFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	if _, ok := n.Function.(*ast.Name); ok {
+		ff.calls[strings.ToLower(NameString(n.Function))] = true
+	}
+}
+
+func (ff *functionFinder) ExprMethodCall(n *ast.ExprMethodCall) {
+	if id, ok := n.Method.(*ast.Identifier); ok {
+		ff.calls[strings.ToLower(string(id.Value))] = true
+	}
+}
+
+func (ff *functionFinder) ExprStaticCall(n *ast.ExprStaticCall) {
+	if id, ok := n.Call.(*ast.Identifier); ok {
+		ff.calls[strings.ToLower(string(id.Value))] = true
+	}
+}
+
+// keyFinder visitor collects the literal keys of storage calls, the way the
+// traverser and analyzer name them
+type keyFinder struct {
+	visitor.Null
+	data   map[string]Vuln
+	writes []string
+	reads  []string
+}
+
+func (kf *keyFinder) call(n ast.Vertex, name string, reads bool) {
+	for _, vuln := range kf.data {
+		if w, ok := vuln.Writes[name]; ok && len(w) == 2 {
+			if key, ok := StorageArg(name, n, w[0]); ok {
+				kf.writes = appendUnique(kf.writes, StorageKey(name, key))
+			}
+		}
+		if i, ok := vuln.Reads[name]; ok && reads {
+			if key, ok := StorageArg(name, n, i); ok {
+				kf.reads = appendUnique(kf.reads, StorageKey(name, key))
+			}
+		}
+	}
+}
+
+func (kf *keyFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	if _, ok := n.Function.(*ast.Name); ok {
+		kf.call(n, NameString(n.Function), true)
+	}
+}
+
+// only functions and $wpdb queries read storage, methods of the same name still write it
+func (kf *keyFinder) ExprMethodCall(n *ast.ExprMethodCall) {
+	if id, ok := n.Method.(*ast.Identifier); ok {
+		kf.call(n, string(id.Value), inList(TableFunctions, string(id.Value)))
+	}
+}
+
+// a $_SESSION entry assigned is written, any other use of it read
+func (kf *keyFinder) ExprAssign(n *ast.ExprAssign) {
+	if dim, ok := n.Var.(*ast.ExprArrayDimFetch); ok {
+		kf.session(dim, true)
+	}
+}
+
+func (kf *keyFinder) ExprArrayDimFetch(n *ast.ExprArrayDimFetch) {
+	kf.session(n, false)
+}
+
+func (kf *keyFinder) session(n *ast.ExprArrayDimFetch, write bool) {
+	key, ok := SessionKey(n)
+	if !ok {
+		return
+	}
+	for _, vuln := range kf.data {
+		if _, ok := vuln.Writes["$_SESSION"]; ok && write {
+			kf.writes = appendUnique(kf.writes, StorageKey("$_SESSION", key))
+		}
+		if _, ok := vuln.Reads["$_SESSION"]; ok && !write {
+			kf.reads = appendUnique(kf.reads, StorageKey("$_SESSION", key))
+		}
+	}
+}
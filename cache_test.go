@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	c, err := NewCache(t.TempDir(), "data.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("<?php echo $_GET['a'];")
+	tests := []struct {
+		name  string
+		other string
+		same  bool
+	}{
+		{"a.php", "a.php", true},
+		{"a.php", "b.php", false},
+		{"a/index.php", "b/index.php", false},
+	}
+	for _, test := range tests {
+		if same := c.Key(test.name, content) == c.Key(test.other, content); same != test.same {
+			t.Errorf("keys of %s and %s alike: %v, want %v", test.name, test.other, same, test.same)
+		}
+	}
+}
+
+func TestCacheRules(t *testing.T) {
+	rules := func(options ...interface{}) string {
+		c, err := NewCache(t.TempDir(), "data.yaml", options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Rules
+	}
+	// the same build, data file and options find the same things
+	if rules(10, "info") != rules(10, "info") {
+		t.Errorf("rules differ for the same options")
+	}
+	if rules(10, "info") == rules(10, "high") {
+		t.Errorf("rules alike for other options")
+	}
+}
+
+func TestCacheIdenticalFiles(t *testing.T) {
+	c, err := NewCache(t.TempDir(), "data.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("<?php\necho $_GET['a'];")
+	names := []string{"cache/a/index.php", "cache/b/index.php"}
+	for _, name := range names {
+		InMemory.Add(name, content)
+	}
+
+	// the first scan analyzes, the second reads the cache
+	for run := 0; run < 2; run++ {
+		files := map[string]bool{}
+		for _, f := range c.Scan(names, 10, 4, "data.yaml", false, Output{MinSeverity: "info", MinConfidence: "low"}) {
+			files[f.File] = true
+		}
+		for _, name := range names {
+			if !files[name] {
+				t.Errorf("scan %d: no finding in %s", run+1, name)
+			}
+		}
+	}
+}
+
+func TestCacheSubset(t *testing.T) {
+	c, err := NewCache(t.TempDir(), "data.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"subset/a/x.php", "subset/a/y.php", "subset/b/z.php"}
+	for _, name := range names {
+		InMemory.Add(name, []byte("<?php\necho $_GET['a'];"))
+	}
+	out := Output{MinSeverity: "info", MinConfidence: "low"}
+	c.Scan(names, 10, 4, "data.yaml", false, out)
+
+	// y.php is gone from a scanned directory, z.php was only left out
+	c.Scan(names[:1], 10, 4, "data.yaml", false, out)
+	if _, ok := c.index["subset/b/z.php"]; !ok {
+		t.Errorf("a file outside the directories scanned was evicted")
+	}
+	if _, ok := c.index["subset/a/y.php"]; ok {
+		t.Errorf("a file gone from a directory scanned was kept")
+	}
+}
+
+func TestCacheCallers(t *testing.T) {
+	c, err := NewCache(t.TempDir(), "data.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	names := []string{filepath.Join(dir, "helper.php"), filepath.Join(dir, "caller.php"), filepath.Join(dir, "other.php")}
+	write := func(name string, content string) {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(names[0], "<?php\n/** @php-analyzer-sanitizes xss */\nfunction helper($x) { return $x; }")
+	write(names[1], "<?php\n\necho helper($_GET['a']);")
+	write(names[2], "<?php\necho $_GET['b'];")
+	out := Output{MinSeverity: "info", MinConfidence: "low"}
+	if hasFinding(c.Scan(names, 10, 4, "data.yaml", false, out), "xss", 3) {
+		t.Errorf("the caller of a sanitizer reported")
+	}
+
+	// the caller changes alone, what the helper declares still holds in it
+	write(names[1], "<?php\n// reviewed\necho helper($_GET['a']);")
+	before := Files
+	findings := c.Scan(names, 10, 4, "data.yaml", false, out)
+	if analyzed := Files - before; analyzed != 1 {
+		t.Errorf("analyzed %d files, want the caller", analyzed)
+	}
+	if hasFinding(findings, "xss", 3) {
+		t.Errorf("the changed caller of a sanitizer reported")
+	}
+
+	// only the annotation goes, the caller is analyzed again and the other file isn't
+	write(names[0], "<?php\nfunction helper($x) { return $x; }")
+	before = Files
+	findings = c.Scan(names, 10, 4, "data.yaml", false, out)
+	if analyzed := Files - before; analyzed != 2 {
+		t.Errorf("analyzed %d files, want the helper and its caller", analyzed)
+	}
+	if !hasFinding(findings, "xss", 3) {
+		t.Errorf("the caller of a function no longer a sanitizer not reported")
+	}
+}
+
+func TestCacheDrop(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(dir, "data.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"drop/a.php", "drop/b.php"}
+	for _, name := range names {
+		InMemory.Add(name, []byte("<?php\necho $_GET['a'];"))
+	}
+	out := Output{MinSeverity: "info", MinConfidence: "low"}
+	c.Scan(names, 10, 4, "data.yaml", false, out)
+
+	// a.php changes and b.php is gone, only a.php's new summary is left
+	InMemory.Release(names[0])
+	InMemory.Add(names[0], []byte("<?php\necho $_GET['b'];"))
+	c.Scan(names[:1], 10, 4, "data.yaml", false, out)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	if len(files) != 2 || !inList(files, "index") || !inList(files, c.index[names[0]]) {
+		t.Errorf("cache holds %v, want the index and the summary of %s", files, names[0])
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Message says what a finding is on one line, for CI widgets
+func (r *Report) Message(f Finding) string {
+	sink := f.Path[len(f.Path)-1]
+	return fmt.Sprintf("%s: %s reaches %s", f.Type, stepSource(f.Path[0]), sinkName(sink.Stack))
+}
+
+// Trace lists the steps of a finding, a line each
+func (r *Report) Trace(f Finding) string {
+	var lines []string
+	for _, step := range f.Path {
+		lines = append(lines, fmt.Sprintf("%s:%d:%d %s | %s", StepFile(step), step.Line, step.Column, step.Stack, StepCode(step)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkstyle severities are error, warning and info
+var checkstyleSeverity = map[string]string{"critical": "error", "high": "error", "medium": "warning", "low": "info", "info": "info"}
+
+// Checkstyle writes checkstyle XML, a file element per file with an error per finding
+func (r *Report) Checkstyle(w io.Writer) error {
+	type cserror struct {
+		Line     int    `xml:"line,attr"`
+		Column   int    `xml:"column,attr"`
+		Severity string `xml:"severity,attr"`
+		Message  string `xml:"message,attr"`
+		Source   string `xml:"source,attr"`
+	}
+	type csfile struct {
+		Name   string    `xml:"name,attr"`
+		Errors []cserror `xml:"error"`
+	}
+	type checkstyle struct {
+		XMLName xml.Name  `xml:"checkstyle"`
+		Version string    `xml:"version,attr"`
+		Files   []*csfile `xml:"file"`
+	}
+
+	cs := checkstyle{Version: "4.3"}
+	byFile := map[string]*csfile{}
+	for _, f := range r.Findings {
+		file, ok := byFile[f.File]
+		if !ok {
+			file = &csfile{Name: f.File}
+			byFile[f.File] = file
+			cs.Files = append(cs.Files, file)
+		}
+		sink := f.Path[len(f.Path)-1]
+		file.Errors = append(file.Errors, cserror{
+			Line:     sink.Line,
+			Column:   sink.Column,
+			Severity: checkstyleSeverity[f.Severity],
+			Message:  r.Message(f) + "\n" + r.Trace(f),
+			Source:   "php-analyzer." + f.Type,
+		})
+	}
+	return writeXML(w, cs)
+}
+
+// JUnit writes JUnit XML, a suite per vuln type with a failed test case per finding
+func (r *Report) JUnit(w io.Writer) error {
+	type failure struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr"`
+		Text    string `xml:",chardata"`
+	}
+	type testcase struct {
+		Name      string  `xml:"name,attr"`
+		Classname string  `xml:"classname,attr"`
+		File      string  `xml:"file,attr"`
+		Line      int     `xml:"line,attr"`
+		Failure   failure `xml:"failure"`
+	}
+	type testsuite struct {
+		Name      string     `xml:"name,attr"`
+		Tests     int        `xml:"tests,attr"`
+		Failures  int        `xml:"failures,attr"`
+		Testcases []testcase `xml:"testcase"`
+	}
+	type testsuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Name     string       `xml:"name,attr"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Time     string       `xml:"time,attr"`
+		Suites   []*testsuite `xml:"testsuite"`
+	}
+
+	ts := testsuites{Name: "php-analyzer", Tests: len(r.Findings), Failures: len(r.Findings), Time: fmt.Sprintf("%.3f", time.Since(r.Started).Seconds())}
+	byType := map[string]*testsuite{}
+	for _, f := range r.Findings {
+		suite, ok := byType[f.Type]
+		if !ok {
+			suite = &testsuite{Name: f.Type}
+			byType[f.Type] = suite
+			ts.Suites = append(ts.Suites, suite)
+		}
+		sink := f.Path[len(f.Path)-1]
+		suite.Tests++
+		suite.Failures++
+		suite.Testcases = append(suite.Testcases, testcase{
+			Name:      fmt.Sprintf("%s:%d", StepFile(sink), sink.Line),
+			Classname: f.Type,
+			File:      f.File,
+			Line:      sink.Line,
+			Failure:   failure{Message: r.Message(f), Type: f.Severity, Text: r.Trace(f)},
+		})
+	}
+	return writeXML(w, ts)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	bytes, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, bytes)
+	return err
+}
+
+// GitLabSchema is the version of the GitLab SAST report schema written
+const GitLabSchema = "15.0.7"
+
+// GitLab writes a GitLab SAST report
+func (r *Report) GitLab(w io.Writer) error {
+	type identifier struct {
+		Type  string `json:"type"`
+		Name  string `json:"name"`
+		Value string `json:"value"`
+		URL   string `json:"url,omitempty"`
+	}
+	type location struct {
+		File      string `json:"file"`
+		StartLine int    `json:"start_line"`
+		EndLine   int    `json:"end_line"`
+	}
+	type vulnerability struct {
+		ID          string       `json:"id"`
+		Name        string       `json:"name"`
+		Description string       `json:"description"`
+		Severity    string       `json:"severity"`
+		Location    location     `json:"location"`
+		Identifiers []identifier `json:"identifiers"`
+	}
+	type vendor struct {
+		Name string `json:"name"`
+	}
+	type tool struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Vendor  vendor `json:"vendor"`
+	}
+	type scan struct {
+		Analyzer  tool   `json:"analyzer"`
+		Scanner   tool   `json:"scanner"`
+		Type      string `json:"type"`
+		StartTime string `json:"start_time"`
+		EndTime   string `json:"end_time"`
+		Status    string `json:"status"`
+	}
+	type report struct {
+		Version         string          `json:"version"`
+		Vulnerabilities []vulnerability `json:"vulnerabilities"`
+		Scan            scan            `json:"scan"`
+	}
+
+	analyzer := tool{ID: "php-analyzer", Name: "php-analyzer", Version: Version, Vendor: vendor{Name: "php-analyzer"}}
+	rep := report{
+		Version:         GitLabSchema,
+		Vulnerabilities: []vulnerability{},
+		Scan: scan{
+			Analyzer:  analyzer,
+			Scanner:   analyzer,
+			Type:      "sast",
+			StartTime: r.Started.UTC().Format("2006-01-02T15:04:05"),
+			EndTime:   time.Now().UTC().Format("2006-01-02T15:04:05"),
+			Status:    "success",
+		},
+	}
+	fingerprints := Fingerprints(r.Findings)
+	for i, f := range r.Findings {
+		sink := f.Path[len(f.Path)-1]
+		identifiers := []identifier{{Type: "php_analyzer_type", Name: f.Type, Value: f.Type}}
+		if f.CWE != 0 {
+			identifiers = append(identifiers, identifier{Type: "cwe", Name: fmt.Sprintf("CWE-%d", f.CWE), Value: fmt.Sprint(f.CWE), URL: fmt.Sprintf("https://cwe.mitre.org/data/definitions/%d.html", f.CWE)})
+		}
+		rep.Vulnerabilities = append(rep.Vulnerabilities, vulnerability{
+			ID:          fingerprints[i], // stable across scans so GitLab can track a finding
+			Name:        r.Message(f),
+			Description: r.Trace(f),
+			Severity:    capitalize(f.Severity),
+			Location:    location{File: f.File, StartLine: sink.Line, EndLine: sink.EndLine},
+			Identifiers: identifiers,
+		})
+	}
+
+	bytes, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", bytes)
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckstyle(t *testing.T) {
+	r, a, b := formatsReport(t)
+	var buf bytes.Buffer
+	if err := r.Checkstyle(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var cs struct {
+		Version string `xml:"version,attr"`
+		Files   []struct {
+			Name   string `xml:"name,attr"`
+			Errors []struct {
+				Line     int    `xml:"line,attr"`
+				Column   int    `xml:"column,attr"`
+				Severity string `xml:"severity,attr"`
+				Message  string `xml:"message,attr"`
+				Source   string `xml:"source,attr"`
+			} `xml:"error"`
+		} `xml:"file"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &cs); err != nil {
+		t.Fatal(err)
+	}
+	if cs.Version != "4.3" || len(cs.Files) != 2 {
+		t.Fatalf("version %s with %d files, want 4.3 with 2", cs.Version, len(cs.Files))
+	}
+	tests := []struct {
+		file     string
+		line     int
+		severity string
+		source   string
+		trace    string
+	}{
+		{a, 3, "warning", "php-analyzer.xss", a + ":2:1 [assign] $x <- [taint] $_GET | $x = $_GET['a']\n" + a + ":3:1"},
+		{b, 4, "error", "php-analyzer.rce", b + ":4:1 [sink] system <- [taint] $_GET | system($_GET['c'] . \"|, <b>\")"},
+	}
+	for i, test := range tests {
+		f := cs.Files[i]
+		if f.Name != test.file || len(f.Errors) != 1 {
+			t.Errorf("file %s with %d errors, want %s with 1", f.Name, len(f.Errors), test.file)
+			continue
+		}
+		e := f.Errors[0]
+		if e.Line != test.line || e.Column != 1 || e.Severity != test.severity || e.Source != test.source {
+			t.Errorf("%s: error %+v, want line %d severity %s source %s", test.file, e, test.line, test.severity, test.source)
+		}
+		if !strings.Contains(e.Message, test.trace) {
+			t.Errorf("%s: message %q, want the trace %q", test.file, e.Message, test.trace)
+		}
+	}
+}
+
+func TestJUnit(t *testing.T) {
+	r, a, b := formatsReport(t)
+	var buf bytes.Buffer
+	if err := r.JUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	type testsuite struct {
+		Name      string `xml:"name,attr"`
+		Tests     int    `xml:"tests,attr"`
+		Failures  int    `xml:"failures,attr"`
+		Testcases []struct {
+			Name      string `xml:"name,attr"`
+			Classname string `xml:"classname,attr"`
+			File      string `xml:"file,attr"`
+			Line      int    `xml:"line,attr"`
+			Failure   struct {
+				Type string `xml:"type,attr"`
+				Text string `xml:",chardata"`
+			} `xml:"failure"`
+		} `xml:"testcase"`
+	}
+	var ts struct {
+		Name     string      `xml:"name,attr"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Suites   []testsuite `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &ts); err != nil {
+		t.Fatal(err)
+	}
+	if ts.Name != "php-analyzer" || ts.Tests != 2 || ts.Failures != 2 || len(ts.Suites) != 2 {
+		t.Fatalf("%s: %d tests, %d failures in %d suites, want 2, 2 in 2", ts.Name, ts.Tests, ts.Failures, len(ts.Suites))
+	}
+	tests := []struct {
+		suite    string
+		name     string
+		severity string
+	}{
+		{"xss", a + ":3", "medium"},
+		{"rce", b + ":4", "critical"},
+	}
+	for i, test := range tests {
+		s := ts.Suites[i]
+		if s.Name != test.suite || s.Tests != 1 || s.Failures != 1 || len(s.Testcases) != 1 {
+			t.Errorf("suite %s: %d tests, %d failures, want %s with 1", s.Name, s.Tests, s.Failures, test.suite)
+			continue
+		}
+		c := s.Testcases[0]
+		if c.Name != test.name || c.Classname != test.suite || c.Failure.Type != test.severity || !strings.Contains(c.Failure.Text, test.name) {
+			t.Errorf("suite %s: test case %+v, want %s failing with %s", s.Name, c, test.name, test.severity)
+		}
+	}
+}
+
+func TestGitLab(t *testing.T) {
+	r, a, b := formatsReport(t)
+	r.Started = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	if err := r.GitLab(&buf); err != nil {
+		t.Fatal(err)
+	}
+	type tool struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Vendor  struct {
+			Name string `json:"name"`
+		} `json:"vendor"`
+	}
+	var rep struct {
+		Version         string `json:"version"`
+		Vulnerabilities []struct {
+			ID          string `json:"id"`
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Severity    string `json:"severity"`
+			Location    struct {
+				File      string `json:"file"`
+				StartLine int    `json:"start_line"`
+				EndLine   int    `json:"end_line"`
+			} `json:"location"`
+			Identifiers []struct {
+				Type  string `json:"type"`
+				Name  string `json:"name"`
+				Value string `json:"value"`
+				URL   string `json:"url"`
+			} `json:"identifiers"`
+		} `json:"vulnerabilities"`
+		Scan struct {
+			Analyzer  tool   `json:"analyzer"`
+			Scanner   tool   `json:"scanner"`
+			Type      string `json:"type"`
+			StartTime string `json:"start_time"`
+			EndTime   string `json:"end_time"`
+			Status    string `json:"status"`
+		} `json:"scan"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Version != GitLabSchema {
+		t.Errorf("schema version %s, want %s", rep.Version, GitLabSchema)
+	}
+	s := rep.Scan
+	if s.Type != "sast" || s.Status != "success" || s.StartTime != "2024-01-02T03:04:05" {
+		t.Errorf("scan %s %s started %s, want sast success started 2024-01-02T03:04:05", s.Type, s.Status, s.StartTime)
+	}
+	if _, err := time.Parse("2006-01-02T15:04:05", s.EndTime); err != nil {
+		t.Errorf("end time: %v", err)
+	}
+	for _, tool := range []tool{s.Analyzer, s.Scanner} {
+		if tool.ID != "php-analyzer" || tool.Name != "php-analyzer" || tool.Version != Version || tool.Vendor.Name != "php-analyzer" {
+			t.Errorf("tool %+v, want php-analyzer %s", tool, Version)
+		}
+	}
+
+	fingerprints := Fingerprints(r.Findings)
+	tests := []struct {
+		file     string
+		line     int
+		severity string
+		cwe      string
+	}{
+		{a, 3, "Medium", "79"},
+		{b, 4, "Critical", "78"},
+	}
+	if len(rep.Vulnerabilities) != len(tests) {
+		t.Fatalf("%d vulnerabilities, want %d", len(rep.Vulnerabilities), len(tests))
+	}
+	for i, test := range tests {
+		v := rep.Vulnerabilities[i]
+		if v.ID != fingerprints[i] || v.Name == "" || v.Description == "" {
+			t.Errorf("%s: id %q name %q, want id %q and a name and description", test.file, v.ID, v.Name, fingerprints[i])
+		}
+		if v.Severity != test.severity || v.Location.File != test.file || v.Location.StartLine != test.line || v.Location.EndLine != test.line {
+			t.Errorf("%s: %s at %+v, want %s at line %d", test.file, v.Severity, v.Location, test.severity, test.line)
+		}
+		if len(v.Identifiers) != 2 || v.Identifiers[1].Type != "cwe" || v.Identifiers[1].Value != test.cwe || v.Identifiers[1].URL == "" {
+			t.Errorf("%s: identifiers %+v, want a type and CWE-%s", test.file, v.Identifiers, test.cwe)
+		}
+	}
+}
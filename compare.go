@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/VKCOM/noverify/src/php/parseutil"
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"gopkg.in/yaml.v2"
+)
+
+// GuardCalls are checks security releases add besides the filters of the
+// data file, by kind
+var GuardCalls = map[string][]string{
+	"nonce":      {"wp_verify_nonce", "check_admin_referer", "check_ajax_referer", "verify_nonce"},
+	"capability": {"current_user_can", "current_user_can_for_blog", "user_can", "author_can", "is_super_admin", "is_user_logged_in"},
+}
+
+// Comparison is what changed between two versions of a codebase
+type Comparison struct {
+	Old        string
+	New        string
+	Fixed      []Finding
+	Introduced []Finding
+	Persisting []Finding
+	// sanitizer, nonce and capability calls the new version has more of
+	Guards []Guard
+}
+
+// Guard is a filter or check a function calls more often in the new version,
+// Lines are those of the calls whose code the old version doesn't have there
+type Guard struct {
+	File     string
+	Function string
+	Kind     string
+	Call     string
+	Added    int
+	Lines    []int
+	// the code of each call, by line
+	code []string
+}
+
+// Compare scans both versions and matches their findings by fingerprint,
+// by their path below each version
+func Compare(oldRoot string, newRoot string, depth int, threads int, datafile string, gadgets bool, out Output) (*Comparison, error) {
+	oldFiles, err := phpFiles(oldRoot)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := phpFiles(newRoot)
+	if err != nil {
+		return nil, err
+	}
+	oldPrefix := filepath.Clean(oldRoot) + string(filepath.Separator)
+	newPrefix := filepath.Clean(newRoot) + string(filepath.Separator)
+
+	c := &Comparison{Old: oldRoot, New: newRoot}
+	old := scan(oldFiles, depth, threads, datafile, gadgets, out)
+	oldFingerprints := Fingerprints(trimFiles(old, oldPrefix))
+	before := map[string]bool{}
+	for _, fingerprint := range oldFingerprints {
+		before[fingerprint] = true
+	}
+	found := scan(newFiles, depth, threads, datafile, gadgets, out)
+	newFingerprints := Fingerprints(trimFiles(found, newPrefix))
+	after := map[string]bool{}
+	for _, fingerprint := range newFingerprints {
+		after[fingerprint] = true
+	}
+	for i, f := range found {
+		if before[newFingerprints[i]] {
+			f.Status = "persisting"
+			c.Persisting = append(c.Persisting, f)
+		} else {
+			f.Status = "new"
+			c.Introduced = append(c.Introduced, f)
+		}
+	}
+	for i, f := range old {
+		if !after[oldFingerprints[i]] {
+			f.Status = "fixed"
+			c.Fixed = append(c.Fixed, f)
+		}
+	}
+
+	data := NewAnalyzer("", datafile).Data
+	c.Guards = addedGuards(guardsIn(oldFiles, oldPrefix, data), guardsIn(newFiles, newPrefix, data))
+	return c, nil
+}
+
+// Findings are the findings of a comparison in the order reports show them
+func (c *Comparison) Findings() []Finding {
+	var findings []Finding
+	findings = append(findings, c.Introduced...)
+	findings = append(findings, c.Fixed...)
+	return append(findings, c.Persisting...)
+}
+
+func writeComparison(c *Comparison, format string) {
+	var (
+		bytes []byte
+		err   error
+	)
+	if format == "yaml" {
+		bytes, err = yaml.Marshal(c)
+	} else {
+		bytes, err = json.Marshal(c)
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Println(string(bytes))
+}
+
+// phpFiles lists the PHP files below a directory
+func phpFiles(root string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".php") {
+			names = append(names, path)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// guardsIn finds the guard calls of every file, keyed by file, function and call
+func guardsIn(names []string, prefix string, data map[string]Vuln) map[string]*Guard {
+	kinds := map[string]string{}
+	for _, vuln := range data {
+		for _, f := range vuln.Filters {
+			kinds[f] = "sanitizer"
+		}
+	}
+	for kind, calls := range GuardCalls {
+		for _, call := range calls {
+			kinds[call] = kind
+		}
+	}
+
+	guards := map[string]*Guard{}
+	for _, name := range names {
+		content, err := readFile(name)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		root, err := parseutil.ParseFile(content)
+		if err != nil {
+			log.Println(err, name)
+			continue
+		}
+		gf := &guardFinder{kinds: kinds}
+		root.Accept(traverser.NewTraverser(gf))
+		file := strings.TrimPrefix(name, prefix)
+		for _, call := range gf.calls {
+			function := gf.scope(call.vertex)
+			key := file + "\x00" + function + "\x00" + call.name
+			g, ok := guards[key]
+			if !ok {
+				g = &Guard{File: file, Function: function, Kind: kinds[call.name], Call: call.name}
+				guards[key] = g
+			}
+			pos := call.vertex.GetPosition()
+			g.Added++
+			g.Lines = append(g.Lines, pos.StartLine)
+			g.code = append(g.code, strings.Join(strings.Fields(string(content[pos.StartPos:pos.EndPos])), " "))
+		}
+	}
+	return guards
+}
+
+// addedGuards keeps the guards called more often in the new version, Added
+// becoming how many more and Lines only listing the calls added
+func addedGuards(old map[string]*Guard, new map[string]*Guard) []Guard {
+	var added []Guard
+	for key, g := range new {
+		n := g.Added
+		if o, ok := old[key]; ok {
+			n -= o.Added
+			g.Lines = g.addedLines(o)
+		}
+		if n > 0 {
+			g.Added = n
+			added = append(added, *g)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		if added[i].File != added[j].File {
+			return added[i].File < added[j].File
+		}
+		if added[i].Lines[0] != added[j].Lines[0] {
+			return added[i].Lines[0] < added[j].Lines[0]
+		}
+		return added[i].Call < added[j].Call
+	})
+	return added
+}
+
+// addedLines are the lines of the calls with no call of the same code in the
+// old version, moved calls keep their code, edited ones count as added
+func (g *Guard) addedLines(old *Guard) []int {
+	before := map[string]int{}
+	for _, code := range old.code {
+		before[code]++
+	}
+	var lines []int
+	for i, code := range g.code {
+		if before[code] > 0 {
+			before[code]--
+			continue
+		}
+		lines = append(lines, g.Lines[i])
+	}
+	return lines
+}
+
+type guardCall struct {
+	name   string
+	vertex ast.Vertex
+}
+
+type guardScope struct {
+	name   string
+	vertex ast.Vertex
+}
+
+// guardFinder visitor collects the guard calls of a file and the functions they are in
+type guardFinder struct {
+	visitor.Null
+	kinds  map[string]string
+	calls  []guardCall
+	scopes []guardScope
+}
+
+// scope names the innermost function around a vertex, empty at the top level
+func (gf *guardFinder) scope(n ast.Vertex) string {
+	name, width := "", -1
+	for _, s := range gf.scopes {
+		pos := s.vertex.GetPosition()
+		if Contains(s.vertex, n) && (width < 0 || pos.EndPos-pos.StartPos < width) {
+			name, width = s.name, pos.EndPos-pos.StartPos
+		}
+	}
+	return name
+}
+
+func (gf *guardFinder) call(n ast.Vertex, name string) {
+	if _, ok := gf.kinds[name]; ok && n.GetPosition() != nil {
+		gf.calls = append(gf.calls, guardCall{name: name, vertex: n})
+	}
+}
+
+func (gf *guardFinder) StmtFunction(n *ast.StmtFunction) {
+	if id, ok := n.Name.(*ast.Identifier); ok && n.GetPosition() != nil {
+		gf.scopes = append(gf.scopes, guardScope{name: string(id.Value), vertex: n})
+	}
+}
+
+func (gf *guardFinder) StmtClass(n *ast.StmtClass) {
+	if id, ok := n.Name.(*ast.Identifier); ok {
+		gf.methods(string(id.Value), n.Stmts)
+	}
+}
+
+func (gf *guardFinder) StmtTrait(n *ast.StmtTrait) {
+	if id, ok := n.Name.(*ast.Identifier); ok {
+		gf.methods(string(id.Value), n.Stmts)
+	}
+}
+
+func (gf *guardFinder) methods(class string, stmts []ast.Vertex) {
+	for _, stmt := range stmts {
+		method, ok := stmt.(*ast.StmtClassMethod)
+		if !ok || method.GetPosition() == nil {
+			continue
+		}
+		if id, ok := method.Name.(*ast.Identifier); ok {
+			gf.scopes = append(gf.scopes, guardScope{name: class + "::" + string(id.Value), vertex: method})
+		}
+	}
+}
+
+func (gf *guardFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	gf.call(n, NameString(n.Function))
+}
+
+func (gf *guardFinder) ExprMethodCall(n *ast.ExprMethodCall) {
+	method := NameString(n.Method)
+	if obj, ok := n.Var.(*ast.ExprVariable); ok {
+		if _, known := gf.kinds[NameString(obj.Name)+"->"+method]; known {
+			gf.call(n, NameString(obj.Name)+"->"+method)
+			return
+		}
+	}
+	gf.call(n, method)
+}
+
+func (gf *guardFinder) ExprStaticCall(n *ast.ExprStaticCall) {
+	gf.call(n, NameString(n.Call))
+}
+
+// casts are filters by the name the traverser gives them
+func (gf *guardFinder) ExprCastInt(n *ast.ExprCastInt)       { gf.call(n, "(int)") }
+func (gf *guardFinder) ExprCastBool(n *ast.ExprCastBool)     { gf.call(n, "(bool)") }
+func (gf *guardFinder) ExprCastDouble(n *ast.ExprCastDouble) { gf.call(n, "(double)") }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tree writes PHP files below a new directory
+func tree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCompare(t *testing.T) {
+	old := tree(t, map[string]string{
+		"a.php": "<?php\necho $_GET['a'];\necho $_GET['b'];",
+	})
+	new := tree(t, map[string]string{
+		"a.php": "<?php\n// kept\necho $_GET['a'];\necho htmlspecialchars($_GET['b']);\necho $_GET['c'];",
+	})
+	c, err := Compare(old, new, 10, 4, "data.yaml", false, Output{MinSeverity: "info", MinConfidence: "low"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status   string
+		findings []Finding
+		line     int
+	}{
+		{"fixed", c.Fixed, 3},
+		{"new", c.Introduced, 5},
+		{"persisting", c.Persisting, 3},
+	}
+	for _, test := range tests {
+		var lines []int
+		for _, f := range test.findings {
+			if f.Type != "xss" {
+				continue
+			}
+			if f.Status != test.status {
+				t.Errorf("%s finding has status %s", test.status, f.Status)
+			}
+			lines = append(lines, f.Path[len(f.Path)-1].Line)
+		}
+		if !reflect.DeepEqual(lines, []int{test.line}) {
+			t.Errorf("%s findings on lines %v, want %d", test.status, lines, test.line)
+		}
+	}
+
+	want := []Guard{{File: "a.php", Kind: "sanitizer", Call: "htmlspecialchars", Added: 1, Lines: []int{4}}}
+	for i := range c.Guards {
+		c.Guards[i].code = nil
+	}
+	if !reflect.DeepEqual(c.Guards, want) {
+		t.Errorf("guards %+v, want %+v", c.Guards, want)
+	}
+}
+
+func TestAddedGuards(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []Guard
+	}{
+		{
+			"check added next to one that moved",
+			"<?php\nfunction save() {\ncheck_admin_referer('a');\n}",
+			"<?php\nfunction save() {\ncheck_admin_referer('b');\ncheck_admin_referer('a');\n}",
+			[]Guard{{File: "a.php", Function: "save", Kind: "nonce", Call: "check_admin_referer", Added: 1, Lines: []int{3}}},
+		},
+		{
+			"method of a class",
+			"<?php\nclass C {\nfunction run() {}\n}",
+			"<?php\nclass C {\nfunction run() {\nif (!current_user_can('edit_posts')) { return; }\n}\n}",
+			[]Guard{{File: "a.php", Function: "C::run", Kind: "capability", Call: "current_user_can", Added: 1, Lines: []int{4}}},
+		},
+		{
+			"check moved and reformatted",
+			"<?php\nwp_verify_nonce($_POST['n'], 'save');",
+			"<?php\n\nwp_verify_nonce( $_POST['n'], 'save' );",
+			nil,
+		},
+		{
+			"check removed",
+			"<?php\nabsint($_GET['a']);",
+			"<?php\n",
+			nil,
+		},
+		{
+			"cast added",
+			"<?php\necho $_GET['a'];",
+			"<?php\necho (int) $_GET['a'];",
+			[]Guard{{File: "a.php", Kind: "sanitizer", Call: "(int)", Added: 1, Lines: []int{2}}},
+		},
+	}
+	data := NewAnalyzer("", "data.yaml").Data
+	for _, test := range tests {
+		old, new := tree(t, map[string]string{"a.php": test.old}), tree(t, map[string]string{"a.php": test.new})
+		prefix := func(dir string) string { return dir + string(filepath.Separator) }
+		got := addedGuards(
+			guardsIn([]string{filepath.Join(old, "a.php")}, prefix(old), data),
+			guardsIn([]string{filepath.Join(new, "a.php")}, prefix(new), data),
+		)
+		for i := range got {
+			got[i].code = nil
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompareArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+		format  string
+		dirs    []string
+	}{
+		{[]string{"compare", "-format", "html", "v1", "v2"}, "compare", "html", []string{"v1", "v2"}},
+		{[]string{"compare", "v1", "v2"}, "compare", "json", []string{"v1", "v2"}},
+		{[]string{"-format", "html", "compare"}, "", "html", []string{"compare"}},
+		{nil, "", "json", nil},
+	}
+	for _, test := range tests {
+		args, command := splitCommand(test.args)
+		fs := flag.NewFlagSet("php-analyzer", flag.ContinueOnError)
+		format := fs.String("format", "json", "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if command != test.command || *format != test.format || !reflect.DeepEqual(fs.Args(), test.dirs) {
+			t.Errorf("%v: command %q, format %q, dirs %v", test.args, command, *format, fs.Args())
+		}
+	}
+}
@@ -0,0 +1,935 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+)
+
+// SinkContext describes where a taint landed inside a sink call
+type SinkContext struct {
+	Call   ast.Vertex
+	Arg    int
+	Prefix string
+	// variables and properties holding DOMXPath or SimpleXMLElement objects
+	XML map[string]bool
+	// the variables the taint went through and the request entry it was read from
+	Flow []string
+	// the checks of what upload validators say the file branches on
+	UploadGuards *Conditions
+	// the host allow-lists the file branches on
+	HostGuards *Conditions
+	// the calls disabling the entity loader
+	LoaderOff *Conditions
+}
+
+// ContextModels decide whether a taint is dangerous where it landed,
+// vulns opt in per sink with the contexts map in the data file
+var ContextModels = map[string]func(SinkContext) bool{
+	// the taint controls the host of a url
+	"url": func(c SinkContext) bool {
+		return !HostFixed(c.Prefix) && !c.HostGuards.Guarded(c.Call, c.Flow)
+	},
+	// header("Location: ...") with the taint in the host
+	"redirect": func(c SinkContext) bool {
+		prefix := strings.TrimLeft(c.Prefix, " \t")
+		if !strings.HasPrefix(strings.ToLower(prefix), "location:") {
+			return false
+		}
+		return !HostFixed(strings.TrimSpace(prefix[len("location:"):])) && !c.HostGuards.Guarded(c.Call, c.Flow)
+	},
+	// a path only opens a phar:// archive, and unserializes its metadata,
+	// when nothing literal comes before the taint
+	"phar": func(c SinkContext) bool {
+		return c.Prefix == ""
+	},
+	// an upload is only unrestricted if nothing checks its type
+	// on the way from $_FILES to the sink
+	"upload": func(c SinkContext) bool {
+		return !c.UploadGuards.Guarded(c.Call, c.Flow)
+	},
+	// fopen($path, "w"), only opening for writing writes the path
+	"write_mode": func(c SinkContext) bool {
+		mode, ok := LiteralArg(c.Call, 1)
+		return !ok || strings.ContainsAny(mode, "waxc+")
+	},
+	// wp_handle_upload($file, array('test_type' => false))
+	"upload_overrides": func(c SinkContext) bool {
+		return c.Arg == 0 && ArrayArg(c.Call, 1, "test_type") == "false"
+	},
+	// xml parsed with entity substitution or dtd loading switched on,
+	// and the entity loader not disabled before it in its function
+	"xxe": func(c SinkContext) bool {
+		if c.LoaderOff.Reaches(c.Call) {
+			return false
+		}
+		for i := range CallArgs(c.Call) {
+			if i == c.Arg {
+				continue
+			}
+			for _, name := range ConstNames(Arg(c.Call, i)) {
+				if name == "LIBXML_NOENT" || name == "LIBXML_DTDLOAD" {
+					return true
+				}
+			}
+		}
+		return false
+	},
+	// query and evaluate are common method names, only those called on
+	// a DOMXPath or SimpleXMLElement object count
+	"xpath": func(c SinkContext) bool {
+		call, ok := c.Call.(*ast.ExprMethodCall)
+		return ok && c.XML[ReceiverName(call.Var)]
+	},
+	// curl_setopt($ch, CURLOPT_URL, ...)
+	"curl_url": func(c SinkContext) bool {
+		return ConstArg(c.Call, 1) == "CURLOPT_URL" && !HostFixed(c.Prefix) && !c.HostGuards.Guarded(c.Call, c.Flow)
+	},
+}
+
+// XMLLoaders are the functions returning SimpleXMLElement objects
+var XMLLoaders = []string{"simplexml_load_string", "simplexml_load_file", "simplexml_import_dom"}
+
+// XMLFinder visitor collects the variables and properties assigned DOMXPath
+// and SimpleXMLElement objects
+type XMLFinder struct {
+	visitor.Null
+	Names map[string]bool
+}
+
+func FindXML(n ast.Vertex) map[string]bool {
+	xf := &XMLFinder{Names: map[string]bool{}}
+	n.Accept(traverser.NewTraverser(xf))
+	return xf.Names
+}
+
+func (xf *XMLFinder) ExprAssign(n *ast.ExprAssign) {
+	switch expr := n.Expr.(type) {
+	case *ast.ExprNew:
+		class := NameString(expr.Class)
+		if class != "DOMXPath" && class != "SimpleXMLElement" {
+			return
+		}
+	case *ast.ExprFunctionCall:
+		if !inList(XMLLoaders, NameString(expr.Function)) {
+			return
+		}
+	default:
+		return
+	}
+	if name := ReceiverName(n.Var); name != "" {
+		xf.Names[name] = true
+	}
+}
+
+// loaderOffFinder visitor collects the calls disabling the entity loader
+type loaderOffFinder struct {
+	scopeFinder
+	calls []ast.Vertex
+}
+
+// FindLoaderOff makes each libxml_disable_entity_loader call that disables
+// the loader into a condition holding for what follows it in its function
+func FindLoaderOff(n *ast.Root) *Conditions {
+	lf := &loaderOffFinder{}
+	n.Accept(traverser.NewTraverser(lf))
+	cs := &Conditions{Scopes: lf.scopes}
+	for _, call := range lf.calls {
+		cs.List = append(cs.List, Condition{Within: cs.scopeOf(call, n), After: call.GetPosition().EndPos})
+	}
+	return cs
+}
+
+func (lf *loaderOffFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	if NameString(n.Function) != "libxml_disable_entity_loader" {
+		return
+	}
+	// the argument defaults to true, anything but a literal true may enable it
+	if len(CallArgs(n)) == 0 || strings.EqualFold(ConstArg(n, 0), "true") {
+		lf.calls = append(lf.calls, n)
+	}
+}
+
+// ReceiverName names the variable or property an object is held in
+func ReceiverName(n ast.Vertex) string {
+	switch n := n.(type) {
+	case *ast.ExprVariable:
+		return NameString(n.Name)
+	case *ast.ExprPropertyFetch:
+		return NameString(n.Prop)
+	}
+	return ""
+}
+
+// nameFinder visitor collects the variables, properties and request entries of an expression
+type nameFinder struct {
+	visitor.Null
+	names map[string]bool
+}
+
+func (nf *nameFinder) ExprVariable(n *ast.ExprVariable) {
+	if name := NameString(n.Name); name != "" {
+		nf.names[name] = true
+	}
+}
+
+func (nf *nameFinder) ExprPropertyFetch(n *ast.ExprPropertyFetch) {
+	if name := NameString(n.Prop); name != "" {
+		nf.names[name] = true
+	}
+}
+
+func (nf *nameFinder) ExprArrayDimFetch(n *ast.ExprArrayDimFetch) {
+	if key := RequestKey(n); key != "" {
+		nf.names[key] = true
+	}
+}
+
+// RequestKey names a superglobal entry read with a literal key, $_FILES[file]
+func RequestKey(n *ast.ExprArrayDimFetch) string {
+	v, ok := n.Var.(*ast.ExprVariable)
+	if !ok || !strings.HasPrefix(NameString(v.Name), "$_") {
+		return ""
+	}
+	key, ok := n.Dim.(*ast.ScalarString)
+	if !ok {
+		return ""
+	}
+	return NameString(v.Name) + "[" + Unquote(string(key.Value)) + "]"
+}
+
+// ReadKey finds the superglobal entry a read of a superglobal inside an expression is of
+func ReadKey(n ast.Vertex, read ast.Vertex) string {
+	rf := &readFinder{read: read}
+	n.Accept(traverser.NewTraverser(rf))
+	return rf.key
+}
+
+// readFinder visitor looks for the array access around a superglobal read
+type readFinder struct {
+	visitor.Null
+	read ast.Vertex
+	key  string
+}
+
+func (rf *readFinder) ExprArrayDimFetch(n *ast.ExprArrayDimFetch) {
+	if n.Var == rf.read {
+		rf.key = RequestKey(n)
+	}
+}
+
+// HostChecks are the functions that compare a url or host against an allow-list
+var HostChecks = []string{"in_array", "wp_validate_redirect", "wp_http_validate_url"}
+
+// Condition is a check a sink is only reached through, the sink is in the branch
+// the check passes, or follows a failed check that leaves the function
+type Condition struct {
+	Names  map[string]bool
+	Within ast.Vertex
+	After  int
+}
+
+// Conditions are the checks of a file and the functions they are made in
+type Conditions struct {
+	Scopes []ast.Vertex
+	List   []Condition
+}
+
+// FindConditions collects the conditions of a file that call a check, with the
+// names in its first argument and the urls a host in it was parsed from
+func FindConditions(n *ast.Root, checks []string) *Conditions {
+	cf := &conditionFinder{checks: checks, parsed: map[string][]string{}}
+	n.Accept(traverser.NewTraverser(cf))
+
+	for i, c := range cf.conds {
+		names := map[string]bool{}
+		nf := &nameFinder{names: map[string]bool{}}
+		c.arg.Accept(traverser.NewTraverser(nf))
+		for name := range nf.names {
+			names[name] = true
+			for _, from := range cf.parsed[name] {
+				names[from] = true
+			}
+		}
+		cf.conds[i].names = names
+	}
+	return guards(n, cf.scopes, cf.conds)
+}
+
+// guards makes the ifs of checks into conditions, a failed check only guards
+// what follows it when its branch leaves
+func guards(n *ast.Root, scopes []ast.Vertex, conds []ifCheck) *Conditions {
+	cs := &Conditions{Scopes: scopes}
+	for _, c := range conds {
+		guard := Condition{Names: c.names, Within: c.n.Stmt}
+		if c.negated {
+			if !Leaves(c.n.Stmt) {
+				continue
+			}
+			guard.Within, guard.After = cs.scopeOf(c.n, n), c.n.Position.EndPos
+		}
+		cs.List = append(cs.List, guard)
+	}
+	return cs
+}
+
+// scopeOf finds the innermost function holding a vertex, the file when none does
+func (cs *Conditions) scopeOf(n ast.Vertex, root ast.Vertex) ast.Vertex {
+	scope := root
+	for _, s := range cs.Scopes {
+		if Contains(s, n) && Contains(scope, s) {
+			scope = s
+		}
+	}
+	return scope
+}
+
+// Guarded reports whether a check on a name the taint went through guards a sink
+func (cs *Conditions) Guarded(sink ast.Vertex, flow []string) bool {
+	if cs == nil || sink.GetPosition() == nil {
+		return false
+	}
+	for _, guard := range cs.List {
+		if !cs.covers(guard, sink) {
+			continue
+		}
+		for _, name := range flow {
+			if guard.Names[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reaches reports whether any condition holds at a sink, whatever the sink is passed
+func (cs *Conditions) Reaches(sink ast.Vertex) bool {
+	if cs == nil || sink.GetPosition() == nil {
+		return false
+	}
+	for _, guard := range cs.List {
+		if cs.covers(guard, sink) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether a sink is inside a condition's branch, or follows it
+// in the same function
+func (cs *Conditions) covers(guard Condition, sink ast.Vertex) bool {
+	if !Contains(guard.Within, sink) || sink.GetPosition().StartPos < guard.After {
+		return false
+	}
+	// a failed check only leaves the function it is made in
+	return guard.After == 0 || cs.scopeOf(sink, guard.Within) == guard.Within
+}
+
+// Leaves reports whether a branch ends in return, exit, die, throw or wp_die
+func Leaves(n ast.Vertex) bool {
+	if list, ok := n.(*ast.StmtStmtList); ok {
+		if len(list.Stmts) == 0 {
+			return false
+		}
+		n = list.Stmts[len(list.Stmts)-1]
+	}
+	if expr, ok := n.(*ast.StmtExpression); ok {
+		n = expr.Expr
+	}
+	switch n := n.(type) {
+	case *ast.StmtReturn, *ast.ExprExit, *ast.StmtThrow, *ast.ExprThrow:
+		return true
+	case *ast.ExprFunctionCall:
+		return NameString(n.Function) == "wp_die"
+	}
+	return false
+}
+
+type ifCheck struct {
+	n       *ast.StmtIf
+	arg     ast.Vertex
+	names   map[string]bool
+	negated bool
+}
+
+// scopeFinder visitor collects the functions of a file
+type scopeFinder struct {
+	visitor.Null
+	scopes []ast.Vertex
+}
+
+func (sf *scopeFinder) StmtFunction(n *ast.StmtFunction) {
+	sf.scopes = append(sf.scopes, n)
+}
+
+func (sf *scopeFinder) StmtClassMethod(n *ast.StmtClassMethod) {
+	sf.scopes = append(sf.scopes, n)
+}
+
+func (sf *scopeFinder) ExprClosure(n *ast.ExprClosure) {
+	sf.scopes = append(sf.scopes, n)
+}
+
+func (sf *scopeFinder) ExprArrowFunction(n *ast.ExprArrowFunction) {
+	sf.scopes = append(sf.scopes, n)
+}
+
+// conditionFinder visitor collects the ifs whose condition is a check or its
+// negation, the functions, and the variables assigned a parse_url of another
+type conditionFinder struct {
+	scopeFinder
+	checks []string
+	conds  []ifCheck
+	parsed map[string][]string
+}
+
+func (cf *conditionFinder) StmtIf(n *ast.StmtIf) {
+	cond, negated := n.Cond, false
+	if not, ok := cond.(*ast.ExprBooleanNot); ok {
+		cond, negated = not.Expr, true
+	}
+	call, ok := cond.(*ast.ExprFunctionCall)
+	if !ok || !inList(cf.checks, NameString(call.Function)) || n.Position == nil {
+		return
+	}
+	if arg := Arg(call, 0); arg != nil {
+		cf.conds = append(cf.conds, ifCheck{n: n, arg: arg, negated: negated})
+	}
+}
+
+func (cf *conditionFinder) ExprAssign(n *ast.ExprAssign) {
+	call, ok := n.Expr.(*ast.ExprFunctionCall)
+	name := ReceiverName(n.Var)
+	if !ok || name == "" || NameString(call.Function) != "parse_url" || Arg(call, 0) == nil {
+		return
+	}
+	nf := &nameFinder{names: map[string]bool{}}
+	Arg(call, 0).Accept(traverser.NewTraverser(nf))
+	for from := range nf.names {
+		cf.parsed[name] = append(cf.parsed[name], from)
+	}
+}
+
+// functions that check the extension or mime type of an upload
+var UploadValidators = []string{
+	"wp_check_filetype",
+	"wp_check_filetype_and_ext",
+	"finfo_file",
+	"mime_content_type",
+	"exif_imagetype",
+	"getimagesize",
+	"pathinfo",
+}
+
+// FindUploadChecks collects the conditions of a file on what an upload
+// validator says, taken as true or false, compared, matched or looked up in an
+// allow-list, with the names the validator was passed. Calling one and never
+// looking at the result checks nothing
+func FindUploadChecks(n *ast.Root) *Conditions {
+	uf := &uploadCheckFinder{validated: map[string]map[string]bool{}}
+	n.Accept(traverser.NewTraverser(uf))
+	return guards(n, uf.scopes, uf.conds)
+}
+
+// uploadCheckFinder visitor collects the ifs on a validator's result and the
+// variables holding one
+type uploadCheckFinder struct {
+	scopeFinder
+	validated map[string]map[string]bool
+	conds     []ifCheck
+}
+
+func (uf *uploadCheckFinder) ExprAssign(n *ast.ExprAssign) {
+	if name := ReceiverName(n.Var); name != "" {
+		if names := uf.validates(n.Expr); len(names) > 0 {
+			uf.validated[name] = names
+		}
+	}
+}
+
+func (uf *uploadCheckFinder) StmtIf(n *ast.StmtIf) {
+	cond, negated := n.Cond, false
+	if not, ok := cond.(*ast.ExprBooleanNot); ok {
+		cond, negated = not.Expr, true
+	}
+
+	names := map[string]bool{}
+	switch c := cond.(type) {
+	case *ast.ExprFunctionCall:
+		switch name := NameString(c.Function); {
+		case name == "in_array":
+			names = uf.validates(Arg(c, 0))
+		case name == "preg_match":
+			// the pattern is the allow-list, the name itself can be matched
+			names = uf.validates(Arg(c, 1))
+			if subject := Arg(c, 1); subject != nil {
+				nf := &nameFinder{names: names}
+				subject.Accept(traverser.NewTraverser(nf))
+			}
+		case inList(UploadValidators, name):
+			names = uf.validates(c)
+		}
+	case *ast.ExprVariable, *ast.ExprArrayDimFetch:
+		names = uf.validates(c)
+	case *ast.ExprBinaryIdentical:
+		names = uf.validates(c.Left, c.Right)
+	case *ast.ExprBinaryEqual:
+		names = uf.validates(c.Left, c.Right)
+	case *ast.ExprBinaryNotIdentical:
+		names, negated = uf.validates(c.Left, c.Right), !negated
+	case *ast.ExprBinaryNotEqual:
+		names, negated = uf.validates(c.Left, c.Right), !negated
+	}
+	if len(names) > 0 && n.Position != nil {
+		uf.conds = append(uf.conds, ifCheck{n: n, names: names, negated: negated})
+	}
+}
+
+// validates names what the validators in expressions were passed, called there
+// or earlier for a variable holding their result
+func (uf *uploadCheckFinder) validates(ns ...ast.Vertex) map[string]bool {
+	vf := &validatorFinder{validated: uf.validated, names: &nameFinder{names: map[string]bool{}}}
+	for _, n := range ns {
+		if n != nil {
+			n.Accept(traverser.NewTraverser(vf))
+		}
+	}
+	return vf.names.names
+}
+
+// validatorFinder visitor collects the names passed to the validators of an
+// expression, and to those of the variables in it
+type validatorFinder struct {
+	visitor.Null
+	validated map[string]map[string]bool
+	names     *nameFinder
+}
+
+func (vf *validatorFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	if arg := Arg(n, 0); arg != nil && inList(UploadValidators, NameString(n.Function)) {
+		arg.Accept(traverser.NewTraverser(vf.names))
+	}
+}
+
+func (vf *validatorFinder) ExprVariable(n *ast.ExprVariable) {
+	for name := range vf.validated[NameString(n.Name)] {
+		vf.names.names[name] = true
+	}
+}
+
+// HostFixed reports whether a url prefix already settles the host,
+// so that anything appended only lands in the path or query
+func HostFixed(prefix string) bool {
+	if i := strings.Index(prefix, "://"); i >= 0 {
+		return hostEnds(prefix[i+3:])
+	}
+	if strings.HasPrefix(prefix, "//") || strings.HasPrefix(prefix, "/\\") || strings.HasPrefix(prefix, "\\") {
+		return hostEnds(strings.TrimLeft(prefix, "/\\"))
+	}
+
+	// an absolute path, unless the rest makes it //host
+	if strings.HasPrefix(prefix, "/") {
+		return len(prefix) > 1
+	}
+
+	// a relative url, unless it may still be the scheme of an absolute one,
+	// "http" . "://evil.com" or "http:" . "//evil.com"
+	i := strings.IndexAny(prefix, ":/?#\\")
+	return i > 0 && prefix[i] != ':'
+}
+
+// hostEnds reports whether what follows the // of a url has a host, and
+// ends it
+func hostEnds(rest string) bool {
+	i := strings.IndexAny(rest, "/?#\\")
+	return i > 0
+}
+
+var (
+	sqlLike       = regexp.MustCompile(`\blike\s*$`)
+	sqlOrder      = regexp.MustCompile(`\b(order|group)\s+by(\s+[\w.` + "`" + `]+(\s+(asc|desc))?\s*,)*\s*$`)
+	sqlDirection  = regexp.MustCompile(`\b(order|group)\s+by\s+[\w.` + "`" + `]+\s+$`)
+	sqlLimit      = regexp.MustCompile(`\b(limit(\s+\d+\s*,)?|offset)\s*$`)
+	sqlIdentifier = regexp.MustCompile(`(\b(from|join|into|update|table|select)|\.)\s*$`)
+)
+
+// PositionModels classify where in a query or command a taint lands
+var PositionModels = map[string]func(prefix string) string{
+	"sql":   SQLPosition,
+	"shell": ShellPosition,
+	"html":  HTMLPosition,
+}
+
+// SQLPosition classifies where a value lands in a query from the text before it:
+// quoted, like, numeric, order, limit or identifier
+func SQLPosition(prefix string) string {
+	prefix = strings.ToLower(prefix)
+
+	var quote rune
+	start := 0
+	escaped := false
+	for i, c := range prefix {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quote == 0 && (c == '\'' || c == '"' || c == '`'):
+			quote = c
+			start = i
+		case c == quote:
+			quote = 0
+		}
+	}
+
+	switch quote {
+	case '`':
+		return "identifier"
+	case '\'', '"':
+		if sqlLike.MatchString(prefix[:start]) {
+			return "like"
+		}
+		return "quoted"
+	}
+
+	switch {
+	case sqlOrder.MatchString(prefix), sqlDirection.MatchString(prefix):
+		return "order"
+	case sqlLimit.MatchString(prefix):
+		return "limit"
+	case sqlIdentifier.MatchString(prefix):
+		return "identifier"
+	}
+	return "numeric"
+}
+
+// ShellPosition classifies where a value lands in a command line from the text before it:
+// command, argument, option, single-quoted or double-quoted
+func ShellPosition(prefix string) string {
+	var quote rune
+	start := 0
+	escaped := false
+	for i, c := range prefix {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		case quote == 0 && strings.ContainsRune(";|&\n`(", c):
+			// a new command starts after this
+			start = i + 1
+		}
+	}
+
+	switch quote {
+	case '\'':
+		return "single-quoted"
+	case '"':
+		return "double-quoted"
+	}
+
+	segment := strings.TrimLeft(prefix[start:], " \t")
+	if segment == "" {
+		return "command"
+	}
+	if strings.HasSuffix(segment, " ") || strings.HasSuffix(segment, "\t") {
+		return "argument"
+	}
+
+	// glued onto a word, -o$x or --file=$x
+	words := strings.Fields(segment)
+	if strings.HasPrefix(words[len(words)-1], "-") {
+		return "option"
+	}
+	if len(words) == 1 {
+		return "command"
+	}
+	return "argument"
+}
+
+var htmlScript = regexp.MustCompile(`<script\b[^>]*>`)
+
+// HTMLPosition classifies where a value lands in a page from the markup before it:
+// text, tag, attribute, single-quoted, double-quoted or script
+func HTMLPosition(prefix string) string {
+	prefix = strings.ToLower(prefix)
+
+	var quote rune
+	tag := false
+	value := false
+	for i, c := range prefix {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case !tag:
+			if c == '<' && i+1 < len(prefix) && (prefix[i+1] == '/' || prefix[i+1] >= 'a' && prefix[i+1] <= 'z') {
+				tag = true
+			}
+		case c == '>':
+			tag, value = false, false
+		case value && (c == '\'' || c == '"'):
+			quote = c
+			value = false
+		case c == '=':
+			value = true
+		case c != ' ' && c != '\t' && c != '\n':
+			value = false
+		}
+	}
+
+	switch {
+	case quote == '\'':
+		return "single-quoted"
+	case quote == '"':
+		return "double-quoted"
+	case value:
+		return "attribute"
+	case tag:
+		return "tag"
+	}
+	// after <script> and before </script>
+	if loc := htmlScript.FindAllStringIndex(prefix, -1); loc != nil && !strings.Contains(prefix[loc[len(loc)-1][1]:], "</script") {
+		return "script"
+	}
+	return "text"
+}
+
+// ArgPrefix finds the argument of a call containing the vertex at,
+// and the literal string text of that argument leading up to it
+func ArgPrefix(call ast.Vertex, at ast.Vertex) (int, string) {
+	// backticks are one command string
+	if shell, ok := call.(*ast.ExprShellExec); ok {
+		prefix, _ := partsPrefix(shell.Parts, at)
+		return 0, prefix
+	}
+
+	for i, arg := range CallArgs(call) {
+		if !Contains(arg, at) {
+			continue
+		}
+		if a, ok := arg.(*ast.Argument); ok {
+			arg = a.Expr
+		}
+		prefix, _ := LiteralPrefix(arg, at)
+		return i, prefix
+	}
+	return -1, ""
+}
+
+// HolderPrefix is the literal text before the vertex at in what an assignment,
+// return or call around it holds
+func HolderPrefix(holder ast.Vertex, at ast.Vertex) string {
+	var expr ast.Vertex
+	switch n := holder.(type) {
+	case *ast.ExprAssign:
+		expr = n.Expr
+	case *ast.StmtReturn:
+		expr = n.Expr
+	default:
+		_, prefix := ArgPrefix(holder, at)
+		return prefix
+	}
+	prefix, _ := LiteralPrefix(expr, at)
+	return prefix
+}
+
+func CallArgs(call ast.Vertex) []ast.Vertex {
+	switch n := call.(type) {
+	case *ast.ExprFunctionCall:
+		return n.Args
+	case *ast.ExprMethodCall:
+		return n.Args
+	case *ast.ExprNullsafeMethodCall:
+		return n.Args
+	case *ast.ExprStaticCall:
+		return n.Args
+	case *ast.ExprNew:
+		return n.Args
+	case *ast.StmtEcho:
+		return n.Exprs
+	}
+	return nil
+}
+
+// LiteralPrefix concatenates the literal parts of a string expression
+// that come before the vertex at, the bool is true once at was reached
+func LiteralPrefix(n ast.Vertex, at ast.Vertex) (string, bool) {
+	if n == nil || Contains(at, n) {
+		return "", true
+	}
+
+	switch n := n.(type) {
+	case *ast.ExprBinaryConcat:
+		left, found := LiteralPrefix(n.Left, at)
+		if found {
+			return left, true
+		}
+		right, found := LiteralPrefix(n.Right, at)
+		return left + right, found
+	case *ast.ExprBrackets:
+		return LiteralPrefix(n.Expr, at)
+	case *ast.ScalarEncapsed:
+		return partsPrefix(n.Parts, at)
+	case *ast.ScalarHeredoc:
+		return partsPrefix(n.Parts, at)
+	case *ast.ScalarEncapsedStringPart:
+		return PHPUnescape(string(n.Value), '"'), false
+	case *ast.ScalarString:
+		str := string(n.Value)
+		if len(str) > 0 && (str[0] == '\'' || str[0] == '"') {
+			return PHPUnescape(Unquote(str), str[0]), false
+		}
+		return str, false
+	}
+
+	// some other expression, its value is unknown
+	return "", Contains(n, at)
+}
+
+// LiteralText concatenates the literal parts of a string expression, leaving
+// out what is not known
+func LiteralText(n ast.Vertex) string {
+	text, _ := LiteralPrefix(n, nil)
+	return text
+}
+
+// PHPUnescape resolves the escape sequences of a string literal's body,
+// single quoted strings only know \' and \\
+func PHPUnescape(str string, quote byte) string {
+	replacer := strings.NewReplacer(`\\`, `\`, `\'`, `'`)
+	if quote == '"' {
+		replacer = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\$`, `$`, `\n`, "\n", `\r`, "\r", `\t`, "\t")
+	}
+	return replacer.Replace(str)
+}
+
+func partsPrefix(parts []ast.Vertex, at ast.Vertex) (string, bool) {
+	str := ""
+	for _, part := range parts {
+		s, found := LiteralPrefix(part, at)
+		str += s
+		if found {
+			return str, true
+		}
+	}
+	return str, false
+}
+
+// Arg returns the expression passed as the i'th argument, or nil
+func Arg(call ast.Vertex, i int) ast.Vertex {
+	args := CallArgs(call)
+	if i < 0 || len(args) <= i {
+		return nil
+	}
+	if a, ok := args[i].(*ast.Argument); ok {
+		return a.Expr
+	}
+	return args[i]
+}
+
+// LiteralArg returns the i'th argument of a call if it is a string literal
+func LiteralArg(call ast.Vertex, i int) (string, bool) {
+	str, ok := Arg(call, i).(*ast.ScalarString)
+	if !ok {
+		return "", false
+	}
+	return Unquote(string(str.Value)), true
+}
+
+// ConstArg returns the name of a constant passed as the i'th argument
+func ConstArg(call ast.Vertex, i int) string {
+	c, ok := Arg(call, i).(*ast.ExprConstFetch)
+	if !ok {
+		return ""
+	}
+	return NameString(c.Const)
+}
+
+// ConstNames lists the constants in a flags expression like A | B
+func ConstNames(n ast.Vertex) []string {
+	switch n := n.(type) {
+	case *ast.ExprConstFetch:
+		return []string{NameString(n.Const)}
+	case *ast.ExprBinaryBitwiseOr:
+		return append(ConstNames(n.Left), ConstNames(n.Right)...)
+	case *ast.ExprBrackets:
+		return ConstNames(n.Expr)
+	}
+	return nil
+}
+
+// ArrayArg returns the value of a key in an array literal passed as the i'th argument
+func ArrayArg(call ast.Vertex, i int, key string) string {
+	array, ok := Arg(call, i).(*ast.ExprArray)
+	if !ok {
+		return ""
+	}
+	for _, nn := range array.Items {
+		item, ok := nn.(*ast.ExprArrayItem)
+		if !ok {
+			continue
+		}
+		k, ok := item.Key.(*ast.ScalarString)
+		if !ok || Unquote(string(k.Value)) != key {
+			continue
+		}
+		switch val := item.Val.(type) {
+		case *ast.ExprConstFetch:
+			return strings.ToLower(NameString(val.Const))
+		case *ast.ScalarString:
+			return Unquote(string(val.Value))
+		case *ast.ScalarLnumber:
+			return string(val.Value)
+		}
+	}
+	return ""
+}
+
+func NameString(n ast.Vertex) string {
+	var parts []ast.Vertex
+	switch name := n.(type) {
+	case *ast.Name:
+		parts = name.Parts
+	case *ast.NameFullyQualified:
+		parts = name.Parts
+	case *ast.NameRelative:
+		parts = name.Parts
+	case *ast.Identifier:
+		return string(name.Value)
+	}
+	str := ""
+	for _, v := range parts {
+		str += string(v.(*ast.NamePart).Value)
+	}
+	return str
+}
+
+// Contains reports whether the source range of outer includes inner
+func Contains(outer ast.Vertex, inner ast.Vertex) bool {
+	if outer == nil || inner == nil {
+		return false
+	}
+	o, i := outer.GetPosition(), inner.GetPosition()
+	if o == nil || i == nil {
+		return false
+	}
+	return o.StartPos <= i.StartPos && i.EndPos <= o.EndPos
+}
+
+func inList(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
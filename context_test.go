@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+
+func TestSQLPosition(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"SELECT * FROM t WHERE a='", "quoted"},
+		{`SELECT * FROM t WHERE a="`, "quoted"},
+		{`SELECT * FROM t WHERE a='it\'s `, "quoted"},
+		{"SELECT * FROM t WHERE a='x' AND b=", "numeric"},
+		{"SELECT * FROM t WHERE a LIKE '%", "like"},
+		{"SELECT * FROM t ORDER BY ", "order"},
+		{"SELECT * FROM t ORDER BY a ", "order"},
+		{"SELECT * FROM t ORDER BY a ASC, ", "order"},
+		{"SELECT * FROM t LIMIT ", "limit"},
+		{"SELECT * FROM t LIMIT 10, ", "limit"},
+		{"SELECT * FROM ", "identifier"},
+		{"SELECT * FROM t WHERE `", "identifier"},
+		{"SELECT * FROM t.", "identifier"},
+	}
+	for _, test := range tests {
+		if got := SQLPosition(test.prefix); got != test.want {
+			t.Errorf("SQLPosition(%q) = %q, want %q", test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestShellPosition(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "command"},
+		{"ls; ", "command"},
+		{"ls | ", "command"},
+		{"$(", "command"},
+		{"ls -la ", "argument"},
+		{"ls -la foo", "argument"},
+		{"convert -resize ", "argument"},
+		{"tar -f", "option"},
+		{"curl --output=", "option"},
+		{"echo '", "single-quoted"},
+		{`echo "`, "double-quoted"},
+		{`echo "a\" `, "double-quoted"},
+		{"echo 'a' ", "argument"},
+	}
+	for _, test := range tests {
+		if got := ShellPosition(test.prefix); got != test.want {
+			t.Errorf("ShellPosition(%q) = %q, want %q", test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestHostFixed(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   bool
+	}{
+		{"", false},
+		{"https://example.com/", true},
+		{"https://example.com/path?next=", true},
+		{"https://example.com?next=", true},
+		{"https://", false},
+		{"https://example", false},
+		{"//example.com/", true},
+		{"//", false},
+		{"/", false},
+		{"/\\", false},
+		{"\\\\", false},
+		{"/wp-admin/", true},
+		{"/w", true},
+		{"admin.php?page=", true},
+		{"http", false},
+		{"http:", false},
+		{"index", false},
+	}
+	for _, test := range tests {
+		if got := HostFixed(test.prefix); got != test.want {
+			t.Errorf("HostFixed(%q) = %v, want %v", test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestHTMLPosition(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "text"},
+		{"<div>", "text"},
+		{"a < b and ", "text"},
+		{"<a href='", "single-quoted"},
+		{`<a href="`, "double-quoted"},
+		{`<a title="x > y`, "double-quoted"},
+		{"<input value=", "attribute"},
+		{"<input value= ", "attribute"},
+		{"<input ", "tag"},
+		{"<input value=x ", "tag"},
+		{`<a href="x">`, "text"},
+		{"<script>var a = '", "script"},
+		{"<script>x</script><p>", "text"},
+	}
+	for _, test := range tests {
+		if got := HTMLPosition(test.prefix); got != test.want {
+			t.Errorf("HTMLPosition(%q) = %q, want %q", test.prefix, got, test.want)
+		}
+	}
+}
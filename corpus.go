@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// corpusFlags are the flags of the corpus run itself, the others are passed
+// on to the scan of each project
+var corpusFlags = []string{"corpus", "journal", "projects", "project-timeout", "format", "yaml", "totals"}
+
+// Totals are the counts a scan writes out with -totals when it is done
+type Totals struct {
+	Files   int
+	Vulns   int
+	Gadgets int
+}
+
+func writeTotals(name string, totals Totals) error {
+	content, err := json.Marshal(totals)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, content, 0644)
+}
+
+func readTotals(name string) (Totals, error) {
+	var totals Totals
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return totals, err
+	}
+	err = json.Unmarshal(content, &totals)
+	return totals, err
+}
+
+// Project is the outcome of scanning one project of a corpus, as it is
+// journaled and written out
+type Project struct {
+	Project    string
+	Files      int
+	Time       string
+	Error      string    `json:",omitempty" yaml:",omitempty"`
+	Types      []Count   `json:",omitempty" yaml:",omitempty"`
+	Severities []Count   `json:",omitempty" yaml:",omitempty"`
+	Findings   []Finding `json:",omitempty" yaml:",omitempty"`
+}
+
+// Corpus scans every subdirectory and archive of a directory as a project of
+// its own, each in a process of its own so projects don't share what they
+// store, and journals the projects done so a scan can pick up where it stopped.
+// Projects that failed are kept apart, in the journal name with .failed, and
+// are scanned again on the next run
+type Corpus struct {
+	Root     string
+	Parallel int
+	Timeout  time.Duration
+	// flags for the scan of each project
+	Args []string
+	// each project keeps its own -cache below this one
+	Cache string
+
+	mu      sync.Mutex
+	journal *os.File
+	failed  *os.File
+	done    map[string]bool
+	// scans a project, Scan unless replaced
+	scan func(name string) Project
+}
+
+// NewCorpus opens the journal, and returns the projects it says are done
+func NewCorpus(root string, journal string, parallel int, timeout time.Duration) (*Corpus, []Project, error) {
+	c := &Corpus{Root: root, Parallel: parallel, Timeout: timeout, done: map[string]bool{}}
+	c.scan = c.Scan
+
+	var done []Project
+	content, err := os.ReadFile(journal)
+	if err == nil {
+		s := bufio.NewScanner(bytes.NewReader(content))
+		s.Buffer(nil, 1<<28)
+		for s.Scan() {
+			var p Project
+			// a line cut short by an interruption is scanned again, and so
+			// are failures journaled before they were kept apart
+			if err := json.Unmarshal(s.Bytes(), &p); err != nil || p.Error != "" {
+				continue
+			}
+			if !c.done[p.Project] {
+				c.done[p.Project] = true
+				done = append(done, p)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	c.journal, err = os.OpenFile(journal, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		c.journal.Write([]byte("\n"))
+	}
+	// only the failures of the last run
+	c.failed, err = os.Create(journal + ".failed")
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, done, nil
+}
+
+// PassFlags passes the flags given on to the scan of each project, but for
+// those of the corpus run itself
+func (c *Corpus) PassFlags(datafile string) error {
+	flag.Visit(func(f *flag.Flag) {
+		switch {
+		case inList(corpusFlags, f.Name) || f.Name == "f":
+		case f.Name == "cache":
+			c.Cache, _ = filepath.Abs(f.Value.String())
+		default:
+			c.Args = append(c.Args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	// each project's scan runs in the project, away from a relative data file
+	data, err := filepath.Abs(datafile)
+	if err != nil {
+		return err
+	}
+	c.Args = append(c.Args, "-f="+data)
+	return nil
+}
+
+func writeProject(p Project, format string) {
+	var (
+		bytes []byte
+		err   error
+	)
+	if format == "yaml" {
+		bytes, err = yaml.Marshal(p)
+	} else {
+		bytes, err = json.Marshal(p)
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Println(string(bytes))
+}
+
+// Projects lists the subdirectories and archives of the corpus not done yet
+func (c *Corpus) Projects() ([]string, error) {
+	entries, err := os.ReadDir(c.Root)
+	if err != nil {
+		return nil, err
+	}
+	var projects []string
+	for _, e := range entries {
+		if (e.IsDir() || IsArchive(e.Name())) && !c.done[e.Name()] {
+			projects = append(projects, e.Name())
+		}
+	}
+	return projects, nil
+}
+
+// Run scans the projects left, Parallel at a time, calling done with each as it finishes
+func (c *Corpus) Run(done func(Project)) error {
+	projects, err := c.Projects()
+	if err != nil {
+		return err
+	}
+	defer c.journal.Close()
+	defer c.failed.Close()
+
+	queue := make(chan string)
+	go func() {
+		for _, p := range projects {
+			queue <- p
+		}
+		close(queue)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for name := range queue {
+				p := c.scan(name)
+
+				c.mu.Lock()
+				line, err := json.Marshal(p)
+				if err == nil {
+					w := c.journal
+					if p.Error != "" {
+						w = c.failed
+					}
+					_, err = w.Write(append(line, '\n'))
+				}
+				if err != nil {
+					log.Println("journal:", err)
+				}
+				done(p)
+				c.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Scan runs the scan of one project, findings are named from the project root
+func (c *Corpus) Scan(name string) (p Project) {
+	p.Project = name
+	started := time.Now()
+	defer func() { p.Time = time.Since(started).Round(time.Millisecond).String() }()
+
+	path := filepath.Join(c.Root, name)
+	input := &bytes.Buffer{}
+	dir := ""
+	if IsArchive(name) {
+		// named plugin.zip/path, from the corpus root
+		fmt.Fprintln(input, name)
+		dir = c.Root
+	} else {
+		files, err := phpFiles(path)
+		if err != nil {
+			p.Error = err.Error()
+			return p
+		}
+		for _, f := range files {
+			rel, _ := filepath.Rel(path, f)
+			fmt.Fprintln(input, rel)
+		}
+		dir = path
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	// the scan's counts come back in a file of their own, apart from its log
+	totals, err := os.CreateTemp("", "php-analyzer-totals")
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	totals.Close()
+	defer os.Remove(totals.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	args := append([]string{"-format=json", "-totals=" + totals.Name()}, c.Args...)
+	if c.Cache != "" {
+		args = append(args, "-cache="+filepath.Join(c.Cache, name))
+	}
+	cmd := exec.CommandContext(ctx, self, args...)
+	cmd.Dir = dir
+	cmd.Stdin = input
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+
+	logged := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if ctx.Err() == context.DeadlineExceeded {
+		p.Error = "timed out after " + c.Timeout.String()
+	} else if err != nil {
+		p.Error = err.Error() + ": " + logged[len(logged)-1]
+	} else if t, err := readTotals(totals.Name()); err != nil {
+		p.Error = "no totals: " + err.Error()
+	} else {
+		p.Files = t.Files
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1<<28)
+	for s.Scan() {
+		var f Finding
+		if err := json.Unmarshal(s.Bytes(), &f); err != nil {
+			continue
+		}
+		p.Findings = append(p.Findings, f)
+	}
+
+	r := &Report{Findings: p.Findings}
+	p.Types = r.counts(func(f Finding) string { return f.Type })
+	p.Severities = r.counts(func(f Finding) string { return f.Severity })
+	return p
+}
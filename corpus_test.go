@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs the analyzer itself when a test starts it the way -corpus
+// starts the scan of each project
+func TestMain(m *testing.M) {
+	if os.Getenv("PHP_ANALYZER_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestCorpusJournal(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"done", "failed", "cut", "new"} {
+		if err := os.Mkdir(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal := filepath.Join(t.TempDir(), "journal")
+	// a project done, one journaled as failed before failures were kept
+	// apart and one whose line an interruption cut short
+	lines := `{"Project":"done","Files":3}` + "\n" + `{"Project":"failed","Error":"exit status 2"}` + "\n" + `{"Project":"cut","Fi`
+	if err := os.WriteFile(journal, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, done, err := NewCorpus(root, journal, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Project != "done" || done[0].Files != 3 {
+		t.Errorf("done %+v, want the project done", done)
+	}
+	projects, err := c.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(projects)
+	if want := []string{"cut", "failed", "new"}; !reflect.DeepEqual(projects, want) {
+		t.Errorf("projects %v, want %v", projects, want)
+	}
+
+	// the scan of one project fails again
+	c.scan = func(name string) Project {
+		if name == "failed" {
+			return Project{Project: name, Error: "timed out after 1m0s"}
+		}
+		return Project{Project: name, Files: 1}
+	}
+	var scanned []string
+	if err := c.Run(func(p Project) { scanned = append(scanned, p.Project) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != 3 {
+		t.Errorf("scanned %v, want every project left", scanned)
+	}
+	failed, err := os.ReadFile(journal + ".failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(failed)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"Project":"failed"`) {
+		t.Errorf("failures %q, want the failed project alone", failed)
+	}
+
+	// a run resumed from the journal only scans the failure again
+	c, done, err = NewCorpus(root, journal, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.journal.Close()
+	defer c.failed.Close()
+	projects, err = c.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 3 || !reflect.DeepEqual(projects, []string{"failed"}) {
+		t.Errorf("resumed with %d done and %v left, want 3 done and the failure left", len(done), projects)
+	}
+	if failed, _ := os.ReadFile(journal + ".failed"); len(failed) != 0 {
+		t.Errorf("failures of the last run kept: %q", failed)
+	}
+}
+
+func TestTotals(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "totals")
+	want := Totals{Files: 12, Vulns: 3, Gadgets: 1}
+	if err := writeTotals(name, want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readTotals(name); err != nil || got != want {
+		t.Errorf("read %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestCorpusScan(t *testing.T) {
+	t.Setenv("PHP_ANALYZER_MAIN", "1")
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "plugin", "inc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, source := range map[string]string{
+		"plugin/index.php":   "<?php\necho $_GET['a'];",
+		"plugin/inc/lib.php": "<?php\nsystem($_GET['c']);",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive, err := os.Create(filepath.Join(root, "theme.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(archive)
+	if f, err := w.Create("theme/header.php"); err != nil {
+		t.Fatal(err)
+	} else {
+		f.Write([]byte("<?php\necho $_GET['t'];"))
+	}
+	w.Close()
+	archive.Close()
+
+	data, err := filepath.Abs("data.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Corpus{Root: root, Timeout: time.Minute, Args: []string{"-f=" + data}}
+
+	tests := []struct {
+		project string
+		args    []string
+		timeout time.Duration
+		files   int
+		found   []string
+		err     string
+	}{
+		{"plugin", nil, time.Minute, 2, []string{"rce inc/lib.php", "xss index.php"}, ""},
+		{"theme.zip", nil, time.Minute, 1, []string{"xss theme/header.php"}, ""},
+		{"plugin", []string{"-min-severity=high"}, time.Minute, 2, []string{"rce inc/lib.php"}, ""},
+		{"plugin", []string{"-min-severity=none"}, time.Minute, 0, nil, `unknown severity "none"`},
+		{"plugin", nil, time.Nanosecond, 0, nil, "timed out after 1ns"},
+	}
+	for _, test := range tests {
+		c.Args, c.Timeout = append([]string{"-f=" + data}, test.args...), test.timeout
+		p := c.Scan(test.project)
+
+		var found []string
+		for _, f := range p.Findings {
+			found = append(found, f.Type+" "+f.File)
+		}
+		sort.Strings(found)
+		sort.Strings(test.found)
+		if p.Project != test.project || p.Files != test.files || !reflect.DeepEqual(found, test.found) || !strings.Contains(p.Error, test.err) || (test.err == "") != (p.Error == "") {
+			t.Errorf("%s %v: %d files, found %v, error %q\nwant %d files, found %v, error %q", test.project, test.args, p.Files, found, p.Error, test.files, test.found, test.err)
+		}
+		n := 0
+		for _, count := range p.Types {
+			n += count.Count
+		}
+		if n != len(p.Findings) {
+			t.Errorf("%s %v: types count %d findings of %d", test.project, test.args, n, len(p.Findings))
+		}
+	}
+}
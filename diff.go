@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fingerprints tell findings apart across scans, from their file, type and
+// sink code, so they hold as lines move. Sinks of the same code in a file are
+// numbered from the top, the first keeps the fingerprint of the code alone
+func Fingerprints(findings []Finding) []string {
+	order := make([]int, len(findings))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := findings[order[i]].Path, findings[order[j]].Path
+		sa, sb := a[len(a)-1], b[len(b)-1]
+		if sa.Line != sb.Line {
+			return sa.Line < sb.Line
+		}
+		return sa.Column < sb.Column
+	})
+
+	fingerprints := make([]string, len(findings))
+	seen := map[string]int{}
+	for _, i := range order {
+		f := findings[i]
+		key := f.File + "\x00" + f.Type + "\x00" + StepCode(f.Path[len(f.Path)-1])
+		n := seen[key]
+		seen[key]++
+		if n > 0 {
+			key += "\x00" + strconv.Itoa(n)
+		}
+		sum := sha256.Sum256([]byte(key))
+		fingerprints[i] = hex.EncodeToString(sum[:])
+	}
+	return fingerprints
+}
+
+// Diff is a -diff scan, base..head of a local git repository
+type Diff struct {
+	Repo string
+	Base string
+	Head string
+}
+
+func NewDiff(repo string, revisions string) (*Diff, error) {
+	parts := strings.SplitN(revisions, "..", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("-diff wants base..head, got %q", revisions)
+	}
+	return &Diff{Repo: repo, Base: parts[0], Head: parts[1]}, nil
+}
+
+func (d *Diff) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", d.Repo}, args...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Files reads the PHP files of a revision into memory, named by prefix and
+// their path in the repository, and returns the names
+func (d *Diff) Files(revision string, prefix string) ([]string, error) {
+	archive, err := d.git("archive", "--format=tar", revision)
+	if err != nil {
+		return nil, err
+	}
+	files, err := untar(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		InMemory.Add(prefix+f.Name, f.Content)
+		names = append(names, prefix+f.Name)
+	}
+	return names, nil
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// Changed lists the lines of head that differ from base, by file
+func (d *Diff) Changed() (map[string]map[int]bool, error) {
+	out, err := d.git("diff", "--no-color", "--no-ext-diff", "-U0", d.Base, d.Head)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := map[string]map[int]bool{}
+	file := ""
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1<<24)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+		case strings.HasPrefix(line, "@@"):
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil || file == "/dev/null" {
+				continue
+			}
+			start, _ := strconv.Atoi(m[1])
+			count := 1
+			if m[2] != "" {
+				count, _ = strconv.Atoi(m[2])
+			}
+			if changed[file] == nil {
+				changed[file] = map[int]bool{}
+			}
+			for l := start; l < start+count; l++ {
+				changed[file][l] = true
+			}
+		}
+	}
+	return changed, s.Err()
+}
+
+// touches checks whether the sink or any step of a finding is on a changed line
+func touches(f Finding, changed map[string]map[int]bool) bool {
+	for _, step := range f.Path {
+		lines := changed[step.Filename]
+		for l := step.Line; l <= step.EndLine; l++ {
+			if lines[l] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Review scans base and head, and returns the findings of head on changed lines,
+// new or persisting, with those of base that are gone from head as fixed
+func (d *Diff) Review(depth int, threads int, datafile string, gadgets bool, out Output) ([]Finding, error) {
+	// base files are scanned as base:path, git's name for them, so they
+	// don't meet head's in the caches keyed by file name
+	prefix := d.Base + ":"
+	baseFiles, err := d.Files(d.Base, prefix)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := d.Changed()
+	if err != nil {
+		return nil, err
+	}
+
+	base := scan(baseFiles, depth, threads, datafile, gadgets, out)
+	baseFingerprints := Fingerprints(trimFiles(base, prefix))
+	before := map[string]bool{}
+	for _, fingerprint := range baseFingerprints {
+		before[fingerprint] = true
+	}
+
+	// head is read once base is scanned, a scan keeps only the files it reads
+	headFiles, err := d.Files(d.Head, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	head := scan(headFiles, depth, threads, datafile, gadgets, out)
+	headFingerprints := Fingerprints(head)
+	after := map[string]bool{}
+	for _, fingerprint := range headFingerprints {
+		after[fingerprint] = true
+	}
+	for i, f := range head {
+		if !touches(f, changed) {
+			continue
+		}
+		f.Status = "new"
+		if before[headFingerprints[i]] {
+			f.Status = "persisting"
+		}
+		findings = append(findings, f)
+	}
+	for i, f := range base {
+		if !after[baseFingerprints[i]] {
+			f.Status = "fixed"
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// trimFiles names findings by their path in the repository, for fingerprints
+func trimFiles(findings []Finding, prefix string) []Finding {
+	trimmed := make([]Finding, len(findings))
+	for i, f := range findings {
+		f.File = strings.TrimPrefix(f.File, prefix)
+		trimmed[i] = f
+	}
+	return trimmed
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprints(t *testing.T) {
+	sink := "<?php\nfunction a() { echo $_GET['a']; }\nfunction b() { echo $_GET['a']; }"
+	findings := scanSource(t, sink)
+	fingerprints := Fingerprints(findings)
+	if len(fingerprints) != 2 || fingerprints[0] == fingerprints[1] {
+		t.Errorf("identical sinks in a file share a fingerprint: %v", fingerprints)
+	}
+
+	// a line added above moves both without changing them
+	moved := scanSource(t, "<?php\n\n"+sink[len("<?php\n"):])
+	for i := range moved {
+		moved[i].File = findings[0].File
+	}
+	before := map[string]bool{}
+	for _, fingerprint := range fingerprints {
+		before[fingerprint] = true
+	}
+	for _, fingerprint := range Fingerprints(moved) {
+		if !before[fingerprint] {
+			t.Errorf("fingerprints changed when the lines moved")
+		}
+	}
+}
+
+func TestTouches(t *testing.T) {
+	f := Finding{Path: []Step{
+		{Filename: "a.php", Line: 2, EndLine: 2},
+		{Filename: "a.php", Line: 5, EndLine: 7},
+		{Filename: "b.php", Line: 3, EndLine: 3},
+	}}
+	tests := []struct {
+		name    string
+		changed map[string]map[int]bool
+		want    bool
+	}{
+		{"nothing changed", map[string]map[int]bool{}, false},
+		{"the first step's line", map[string]map[int]bool{"a.php": {2: true}}, true},
+		{"a line inside a step", map[string]map[int]bool{"a.php": {6: true}}, true},
+		{"the last line of a step", map[string]map[int]bool{"a.php": {7: true}}, true},
+		{"lines between steps", map[string]map[int]bool{"a.php": {3: true, 4: true, 8: true}}, false},
+		{"a step in another file", map[string]map[int]bool{"b.php": {3: true}}, true},
+		{"the line in a file no step is in", map[string]map[int]bool{"c.php": {2: true}}, false},
+	}
+	for _, test := range tests {
+		if got := touches(f, test.changed); got != test.want {
+			t.Errorf("%s: touches %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReview(t *testing.T) {
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.org"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, out)
+		}
+	}
+	commit := func(source string) {
+		if err := os.WriteFile(filepath.Join(repo, "a.php"), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "a.php")
+		git("commit", "-q", "-m", "change")
+	}
+	git("init", "-q")
+	commit("<?php\n$b = $_GET['b'];\necho $b;\necho $_GET['a'];\necho $_GET['kept'];\n")
+	git("tag", "base")
+	// a is fixed, b changed on the way to its sink, c is new and kept untouched
+	commit("<?php\n$b = trim($_GET['b']);\necho $b;\necho $_GET['kept'];\necho $_GET['c'];\n")
+
+	d, err := NewDiff(repo, "base..HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := d.Review(10, 2, "data.yaml", false, Output{MinSeverity: "info", MinConfidence: "low"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, f := range findings {
+		if f.Type == "xss" {
+			got[f.Status+" "+StepCode(f.Path[len(f.Path)-1])] = true
+		}
+	}
+	want := map[string]bool{
+		"persisting echo $b;":    true,
+		"new echo $_GET['c'];":   true,
+		"fixed echo $_GET['a'];": true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reviewed %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+)
+
+// Explaining is set by -explain file:line, the engine then records
+// every decision it makes about taints found on that line
+var Explaining *Explainer
+
+type Explainer struct {
+	File string
+	Line int
+
+	mu    sync.Mutex
+	types map[string][]string
+	Lines []string
+}
+
+func NewExplainer(at string) (*Explainer, error) {
+	i := strings.LastIndex(at, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("-explain wants file:line, got %q", at)
+	}
+	line, err := strconv.Atoi(at[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("-explain wants file:line, got %q", at)
+	}
+	return &Explainer{File: filepath.Clean(at[:i]), Line: line, types: map[string][]string{}}, nil
+}
+
+// Covers reports whether a vertex in a file is on the line being explained
+func (e *Explainer) Covers(filename string, n ast.Vertex) bool {
+	if n == nil || n.GetPosition() == nil {
+		return false
+	}
+	pos := n.GetPosition()
+	if pos.StartLine > e.Line || pos.EndLine < e.Line {
+		return false
+	}
+	return filepath.Clean(filename) == e.File || strings.HasSuffix(filepath.Clean(filename), string(filepath.Separator)+e.File)
+}
+
+// Explain records a decision about a taint found at a vertex. The passes over
+// a file repeat the same decisions so each is kept once, with the vuln types
+// it was made for
+func (a *Analyzer) Explain(taint Taint, at ast.Vertex, format string, args ...interface{}) {
+	if Explaining == nil || !Explaining.Covers(a.Filename, at) {
+		return
+	}
+	pos := at.GetPosition()
+	line := fmt.Sprintf("%s:%d %s in %s: %s", a.Filename, pos.StartLine, taint.Name, scopeString(a.CurrentContext), fmt.Sprintf(format, args...))
+
+	Explaining.mu.Lock()
+	defer Explaining.mu.Unlock()
+	types, ok := Explaining.types[line]
+	if !ok {
+		Explaining.Lines = append(Explaining.Lines, line)
+	}
+	if !inList(types, taint.Type) {
+		Explaining.types[line] = append(types, taint.Type)
+	}
+}
+
+func scopeString(c Context) string {
+	switch {
+	case c.Class == "" && c.Block == "":
+		return "top level"
+	case c.Class == "":
+		return c.Block
+	}
+	return c.Class + "::" + c.Block
+}
+
+func writeExplanations(e *Explainer) {
+	if err := e.Write(os.Stdout); err != nil {
+		log.Println(err)
+	}
+}
+
+// Write writes the decisions recorded, each with the vuln types it was made for
+func (e *Explainer) Write(w io.Writer) error {
+	if len(e.Lines) == 0 {
+		_, err := fmt.Fprintf(w, "no taint was found on %s:%d\n", e.File, e.Line)
+		return err
+	}
+	for _, line := range e.Lines {
+		types := e.types[line]
+		sort.Strings(types)
+		if _, err := fmt.Fprintf(w, "%s [%s]\n", line, strings.Join(types, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
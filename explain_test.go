@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// explain runs the analyzer over a PHP source explaining a line of it
+func explain(t *testing.T, source string, line int) string {
+	t.Helper()
+	e, err := NewExplainer(fmt.Sprintf("test.php:%d", line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Explaining = e
+	defer func() { Explaining = nil }()
+	analyze(t, source)
+
+	b := &strings.Builder{}
+	if err := e.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// explained finds a decision made for a vuln type among the lines written
+func explained(out string, decision string, typ string) bool {
+	for _, line := range strings.Split(out, "\n") {
+		i := strings.LastIndex(line, " [")
+		if i < 0 || !strings.HasSuffix(line[:i], decision) {
+			continue
+		}
+		for _, t := range strings.Split(strings.Trim(line[i+2:], "]"), ", ") {
+			if t == typ {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestExplain(t *testing.T) {
+	source := "<?php\n$a = 1;\n$x = htmlspecialchars($_GET['x']);\necho $_GET['y'];"
+	if out, want := explain(t, source, 2), "no taint was found on test.php:2\n"; out != want {
+		t.Errorf("explained %q, want %q", out, want)
+	}
+
+	tests := []struct {
+		line     int
+		decision string
+		typ      string
+		want     bool
+	}{
+		{3, "test.php:3 $_GET in top level: stopped by filter htmlspecialchars", "xss", true},
+		{3, "test.php:3 $_GET in top level: assigned to $x in top level", "rce", true},
+		{4, "test.php:4 $_GET in top level: reported at sink echo", "xss", true},
+		{4, "test.php:4 $_GET in top level: passes through echo, not a sink for the type", "rce", true},
+		{4, "test.php:4 $_GET in top level: goes no further, nothing around it assigns, stores or filters it", "rce", true},
+		// the sink already decided
+		{4, "test.php:4 $_GET in top level: goes no further, nothing around it assigns, stores or filters it", "xss", false},
+	}
+	for _, test := range tests {
+		if out := explain(t, source, test.line); explained(out, test.decision, test.typ) != test.want {
+			t.Errorf("%q for %s: %v, want %v in\n%s", test.decision, test.typ, !test.want, test.want, out)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Markdown writes the report for pasting into tickets, a section per finding
+func (r *Report) Markdown(w io.Writer) error {
+	b := &strings.Builder{}
+	b.WriteString("# php-analyzer report\n\n")
+	var types []string
+	for _, c := range r.counts(func(f Finding) string { return f.Type }) {
+		types = append(types, fmt.Sprintf("%d %s", c.Count, c.Name))
+	}
+	fmt.Fprintf(b, "%d findings", len(r.Findings))
+	if len(types) > 0 {
+		fmt.Fprintf(b, ": %s", strings.Join(types, ", "))
+	}
+	b.WriteString("\n")
+
+	for i, f := range r.Findings {
+		sink := f.Path[len(f.Path)-1]
+		fmt.Fprintf(b, "\n## %d. %s in %s", i+1, f.Type, r.Location(sink, true))
+		if f.Status != "" {
+			fmt.Fprintf(b, " (%s)", f.Status)
+		}
+		b.WriteString("\n\n")
+		fmt.Fprintf(b, "**Severity** %s · **Confidence** %s", f.Severity, f.Confidence)
+		if f.CWE != 0 {
+			fmt.Fprintf(b, " · **CWE** [CWE-%d](https://cwe.mitre.org/data/definitions/%d.html)", f.CWE, f.CWE)
+		}
+		b.WriteString("\n")
+		if len(f.Doubts) > 0 {
+			fmt.Fprintf(b, "\nDoubts: %s\n", strings.Join(f.Doubts, ", "))
+		}
+
+		b.WriteString("\n| # | Step | Code | Location |\n|---|------|------|----------|\n")
+		for j, step := range f.Path {
+			fmt.Fprintf(b, "| %d | %s | %s | %s |\n", j+1, mdCode(step.Stack), mdCode(StepCode(step)), r.Location(step, true))
+		}
+
+		for _, source := range r.Sources(f) {
+			fmt.Fprintf(b, "\n%s\n```php\n", source.File)
+			for _, line := range source.Lines {
+				if line.Gap {
+					b.WriteString("...\n")
+				}
+				fmt.Fprintf(b, "%4d  %s\n", line.Number, line.Text)
+			}
+			b.WriteString("```\n")
+		}
+
+		if f.PoC != nil {
+			fmt.Fprintf(b, "\nProof of concept\n```sh\n%s\n```\n", f.PoC.Curl)
+		}
+	}
+
+	if len(r.Guards) > 0 {
+		b.WriteString("\n## Added guards\n\n| File | Function | Kind | Call | Added | Lines |\n|------|----------|------|------|-------|-------|\n")
+		for _, g := range r.Guards {
+			var lines []string
+			for _, l := range g.Lines {
+				lines = append(lines, fmt.Sprint(l))
+			}
+			fmt.Fprintf(b, "| %s | %s | %s | %s | %d | %s |\n", g.File, mdCode(g.Function), g.Kind, mdCode(g.Call), g.Added, strings.Join(lines, ", "))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mdCode puts text in a table cell as inline code
+func mdCode(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	if strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+	return "`" + s + "`"
+}
+
+// StepCode is the code of a step on one line
+func StepCode(step Step) string {
+	return strings.Join(strings.Fields(step.Code), " ")
+}
+
+// CSV writes a row per finding, for triage in a spreadsheet
+func (r *Report) CSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"File", "Line", "Type", "CWE", "Severity", "Confidence", "Source", "Sink", "Path", "Doubts", "PoC"})
+	for _, f := range r.Findings {
+		sink := f.Path[len(f.Path)-1]
+		var path []string
+		for _, step := range f.Path {
+			path = append(path, StepCode(step))
+		}
+		cwe := ""
+		if f.CWE != 0 {
+			cwe = fmt.Sprint(f.CWE)
+		}
+		poc := ""
+		if f.PoC != nil {
+			poc = f.PoC.Curl
+		}
+		c.Write([]string{f.File, fmt.Sprint(sink.Line), f.Type, cwe, f.Severity, f.Confidence, stepSource(f.Path[0]), StepCode(sink), strings.Join(path, " -> "), strings.Join(f.Doubts, "; "), poc})
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// stepSource reads what the first step was tainted by, the tail of its stack
+func stepSource(step Step) string {
+	parts := strings.Split(step.Stack, " <- ")
+	last := parts[len(parts)-1]
+	if i := strings.Index(last, "] "); i >= 0 {
+		return last[i+2:]
+	}
+	return last
+}
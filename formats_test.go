@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// formatsReport is two findings in two files, with the characters each
+// format has to escape in their code
+func formatsReport(t *testing.T) (*Report, string, string) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.php"), filepath.Join(dir, "b.php")
+	if err := os.WriteFile(a, []byte("<?php\n$x = $_GET['a'];\necho $x;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("<?php\n\n\nsystem($_GET['c'] . \"|, <b>\");"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &Report{Findings: []Finding{
+		{
+			File: a, Type: "xss", CWE: 79, Severity: "medium", Confidence: "high",
+			Path: []Step{
+				{Stack: "[assign] $x <- [taint] $_GET", Code: "$x = $_GET['a']", Filename: a, Line: 2, Column: 1, EndLine: 2, Start: 6, End: 21, Snippet: []SnippetLine{{Line: 2, Code: "$x = $_GET['a'];"}}},
+				{Stack: "[sink] echo <- [taint] $x", Code: "echo $x;", Filename: a, Line: 3, Column: 1, EndLine: 3, Start: 23, End: 31, Snippet: []SnippetLine{{Line: 3, Code: "echo $x;"}}},
+			},
+		},
+		{
+			File: b, Type: "rce", CWE: 78, Severity: "critical", Confidence: "medium", Doubts: []string{"dynamic call"},
+			Path: []Step{
+				{Stack: "[sink] system <- [taint] $_GET", Code: "system($_GET['c'] . \"|, <b>\")", Filename: b, Line: 4, Column: 1, EndLine: 4, Start: 8, End: 38},
+			},
+		},
+	}}, a, b
+}
+
+func TestMarkdown(t *testing.T) {
+	r, a, b := formatsReport(t)
+	var buf bytes.Buffer
+	if err := r.Markdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# php-analyzer report\n\n2 findings: 1 rce, 1 xss\n",
+		"\n## 1. xss in " + a + ":3\n",
+		"\n## 2. rce in " + b + ":4\n",
+		"[CWE-78](https://cwe.mitre.org/data/definitions/78.html)",
+		"\nDoubts: dynamic call\n",
+		"| 1 | `[assign] $x <- [taint] $_GET` | `$x = $_GET['a']` | " + a + ":2 |\n",
+		"| 1 | `[sink] system <- [taint] $_GET` | `system($_GET['c'] . \"\\|, <b>\")` | " + b + ":4 |\n",
+		"\n" + a + "\n```php\n   2  $x = $_GET['a'];\n   3  echo $x;\n```\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown is missing %q:\n%s", want, out)
+		}
+	}
+
+	// every table row has as many cells as its header
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "| ") {
+			if cells := len(strings.Split(strings.ReplaceAll(line, `\|`, ""), "|")); cells != 6 {
+				t.Errorf("row %q has %d cells, want 4", line, cells-2)
+			}
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	r, a, b := formatsReport(t)
+	var buf bytes.Buffer
+	if err := r.CSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"File", "Line", "Type", "CWE", "Severity", "Confidence", "Source", "Sink", "Path", "Doubts", "PoC"},
+		{a, "3", "xss", "79", "medium", "high", "$_GET", "echo $x;", "$x = $_GET['a'] -> echo $x;", "", ""},
+		{b, "4", "rce", "78", "critical", "medium", "$_GET", "system($_GET['c'] . \"|, <b>\")", "system($_GET['c'] . \"|, <b>\")", "dynamic call", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %q, want %q", rows, want)
+	}
+}
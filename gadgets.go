@@ -0,0 +1,295 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+)
+
+// magic methods that run implicitly on a deserialized object
+var MagicMethods = []string{"__wakeup", "__destruct", "__toString", "__call"}
+
+// Gadget is a dangerous operation reachable from a magic method
+type Gadget struct {
+	Class    string
+	Method   string
+	Path     []string
+	Vertex   ast.Vertex
+	Filename string
+}
+
+// GadgetFinder visitor takes an inventory of the classes and traits of every
+// file scanned, the magic methods of each reach dangerous operations through
+// $this-> calls to their own methods, their traits' and their parents'
+type GadgetFinder struct {
+	visitor.Null
+	Dangerous map[string]bool
+
+	mu       sync.Mutex
+	filename string
+	classes  map[string]*gadgetClass
+	names    []string
+}
+
+// gadgetClass is a class or trait as the inventory keeps it, names in lower case
+type gadgetClass struct {
+	name     string
+	display  string
+	parent   string
+	traits   []string
+	methods  map[string]*callCollector
+	filename string
+}
+
+func NewGadgetFinder(data map[string]Vuln) *GadgetFinder {
+	gf := &GadgetFinder{Dangerous: map[string]bool{}, classes: map[string]*gadgetClass{}}
+	for _, vuln := range data {
+		for _, name := range vuln.Gadgets {
+			gf.Dangerous[name] = true
+		}
+	}
+	return gf
+}
+
+// Add takes the classes of a file into the inventory, and reports whether
+// they hold a dangerous operation, for the file to be kept until Find
+func (gf *GadgetFinder) Add(n ast.Vertex, filename string) bool {
+	cf := &GadgetFinder{Dangerous: gf.Dangerous, filename: filename, classes: map[string]*gadgetClass{}}
+	n.Accept(traverser.NewTraverser(cf))
+
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+	dangerous := false
+	for _, name := range cf.names {
+		c := cf.classes[name]
+		for _, m := range c.methods {
+			dangerous = dangerous || len(m.ops) > 0
+		}
+		// the first declaration wins, as PHP only loads one
+		if _, ok := gf.classes[name]; !ok {
+			gf.classes[name] = c
+			gf.names = append(gf.names, name)
+		}
+	}
+	return dangerous
+}
+
+// Find lists the gadgets of every class added
+func (gf *GadgetFinder) Find() []Gadget {
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+
+	type key struct {
+		vertex ast.Vertex
+		path   string
+	}
+	sort.Strings(gf.names)
+	seen := map[key]bool{}
+	var gadgets []Gadget
+	for _, name := range gf.names {
+		for _, g := range gf.gadgets(gf.classes[name]) {
+			// a class that inherits a gadget unchanged repeats it
+			key := key{g.Vertex, strings.Join(g.Path, " ")}
+			if !seen[key] {
+				seen[key] = true
+				gadgets = append(gadgets, g)
+			}
+		}
+	}
+	return gadgets
+}
+
+// method finds a method of a class, in the class, its traits, then its parents
+func (gf *GadgetFinder) method(c *gadgetClass, name string, seen map[*gadgetClass]bool) (*callCollector, *gadgetClass) {
+	if c == nil || seen[c] {
+		return nil, nil
+	}
+	seen[c] = true
+	if m, ok := c.methods[name]; ok {
+		return m, c
+	}
+	for _, t := range c.traits {
+		if m, owner := gf.method(gf.classes[t], name, seen); m != nil {
+			return m, owner
+		}
+	}
+	return gf.method(gf.classes[c.parent], name, seen)
+}
+
+func (gf *GadgetFinder) gadgets(c *gadgetClass) []Gadget {
+	var gadgets []Gadget
+	for _, magic := range MagicMethods {
+		m, owner := gf.method(c, strings.ToLower(magic), map[*gadgetClass]bool{})
+		if m == nil {
+			continue
+		}
+
+		// breadth first through $this-> calls, each method visited once
+		type step struct {
+			method *callCollector
+			owner  *gadgetClass
+			path   []string
+		}
+		seen := map[*callCollector]bool{m: true}
+		queue := []step{{method: m, owner: owner, path: []string{magic}}}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+
+			for _, op := range cur.method.ops {
+				path := append(append([]string{}, cur.path...), op.name)
+				gadgets = append(gadgets, Gadget{Class: c.display, Method: magic, Path: path, Vertex: op.vertex, Filename: cur.owner.filename})
+			}
+			for _, callee := range cur.method.calls {
+				// $this-> calls resolve from the class the object is
+				next, owner := gf.method(c, strings.ToLower(callee), map[*gadgetClass]bool{})
+				if next == nil || seen[next] {
+					continue
+				}
+				seen[next] = true
+				queue = append(queue, step{method: next, owner: owner, path: append(append([]string{}, cur.path...), callee)})
+			}
+		}
+	}
+	return gadgets
+}
+
+func (gf *GadgetFinder) StmtClass(n *ast.StmtClass) {
+	name, ok := n.Name.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	gf.class(string(name.Value), n.Extends, n.Stmts)
+}
+
+func (gf *GadgetFinder) StmtTrait(n *ast.StmtTrait) {
+	name, ok := n.Name.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	gf.class(string(name.Value), nil, n.Stmts)
+}
+
+// className is a class name without its namespace, in lower case
+func className(n ast.Vertex) string {
+	switch name := n.(type) {
+	case *ast.Name:
+		n = name.Parts[len(name.Parts)-1]
+	case *ast.NameFullyQualified:
+		n = name.Parts[len(name.Parts)-1]
+	case *ast.NameRelative:
+		n = name.Parts[len(name.Parts)-1]
+	}
+	switch name := n.(type) {
+	case *ast.NamePart:
+		return strings.ToLower(string(name.Value))
+	case *ast.Identifier:
+		return strings.ToLower(string(name.Value))
+	}
+	return ""
+}
+
+func (gf *GadgetFinder) class(name string, extends ast.Vertex, stmts []ast.Vertex) {
+	c := &gadgetClass{name: strings.ToLower(name), display: name, methods: map[string]*callCollector{}, filename: gf.filename}
+	if extends != nil {
+		c.parent = className(extends)
+	}
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.StmtTraitUse:
+			for _, t := range stmt.Traits {
+				c.traits = append(c.traits, className(t))
+			}
+		case *ast.StmtClassMethod:
+			id, ok := stmt.Name.(*ast.Identifier)
+			if !ok {
+				continue
+			}
+			m := &callCollector{dangerous: gf.Dangerous}
+			if stmt.Stmt != nil {
+				stmt.Stmt.Accept(traverser.NewTraverser(m))
+			}
+			c.methods[strings.ToLower(string(id.Value))] = m
+		}
+	}
+	if _, ok := gf.classes[c.name]; !ok {
+		gf.classes[c.name] = c
+		gf.names = append(gf.names, c.name)
+	}
+}
+
+type operation struct {
+	name   string
+	vertex ast.Vertex
+}
+
+// callCollector visitor records dangerous operations and $this-> calls in a method body
+type callCollector struct {
+	visitor.Null
+	dangerous map[string]bool
+	ops       []operation
+	calls     []string
+}
+
+func (c *callCollector) op(name string, n ast.Vertex) {
+	if c.dangerous[name] {
+		c.ops = append(c.ops, operation{name: name, vertex: n})
+	}
+}
+
+func (c *callCollector) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	funcName, ok := n.Function.(*ast.Name)
+	if !ok {
+		c.op("dynamic_call", n)
+		return
+	}
+	name := ""
+	for _, v := range funcName.Parts {
+		name += string(v.(*ast.NamePart).Value)
+	}
+	c.op(name, n)
+}
+
+func (c *callCollector) ExprMethodCall(n *ast.ExprMethodCall) {
+	id, ok := n.Method.(*ast.Identifier)
+	if !ok {
+		c.op("dynamic_call", n)
+		return
+	}
+	name := string(id.Value)
+	c.op(name, n)
+
+	if obj, ok := n.Var.(*ast.ExprVariable); ok {
+		if oid, ok := obj.Name.(*ast.Identifier); ok && string(oid.Value) == "$this" {
+			c.calls = append(c.calls, name)
+		}
+	}
+}
+
+func (c *callCollector) ExprEval(n *ast.ExprEval) {
+	c.op("eval", n)
+}
+
+func (c *callCollector) ExprShellExec(n *ast.ExprShellExec) {
+	c.op("shell_exec", n)
+}
+
+func (c *callCollector) ExprInclude(n *ast.ExprInclude) {
+	c.op("include", n)
+}
+
+func (c *callCollector) ExprIncludeOnce(n *ast.ExprIncludeOnce) {
+	c.op("include_once", n)
+}
+
+func (c *callCollector) ExprRequire(n *ast.ExprRequire) {
+	c.op("require", n)
+}
+
+func (c *callCollector) ExprRequireOnce(n *ast.ExprRequireOnce) {
+	c.op("require_once", n)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGadgetsAcrossFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := []struct{ name, source string }{
+		{"base.php", "<?php\nclass Base {\nfunction __destruct() { $this->cleanup(); }\nfunction cleanup() {}\n}\ntrait Logs {\nfunction log() { system($this->cmd); }\n}"},
+		{"child.php", "<?php\nclass Child extends Base {\nuse Logs;\nfunction cleanup() { $this->log(); }\n}"},
+	}
+	Queue = make(chan string)
+	Results = make(chan Result)
+	go func() {
+		for _, f := range files {
+			name := filepath.Join(dir, f.name)
+			if err := os.WriteFile(name, []byte(f.source), 0644); err != nil {
+				t.Error(err)
+			}
+			Queue <- name
+		}
+		close(Queue)
+	}()
+	go workers(10, 2, "data.yaml", true)
+
+	var stacks []string
+	for r := range Results {
+		if r.Type == "gadget" {
+			stacks = append(stacks, r.Stack)
+		}
+	}
+	// the destructor of the parent calls the child's override, which uses the trait
+	want := "[gadget] Child::__destruct -> cleanup -> log -> system"
+	if len(stacks) != 1 || stacks[0] != want {
+		t.Errorf("gadgets %s, want %s", strings.Join(stacks, ", "), want)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+)
+
+// GraphFormats are what -graph prints
+var GraphFormats = []string{"dot", "json"}
+
+// Graph is the taint propagation behind one or more findings,
+// JSON is the node-link format d3 and networkx read
+type Graph struct {
+	Directed bool    `json:"directed"`
+	Nodes    []*Node `json:"nodes"`
+	Links    []*Link `json:"links"`
+
+	nodes map[string]*Node
+	links map[string]*Link
+}
+
+// Node is a source, a tainted variable where it was set, or a sink
+type Node struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"` // source, taint or sink
+	Label string `json:"label"`
+	File  string `json:"file"`
+	Line  int    `json:"line,omitempty"`
+	Pos   int    `json:"pos,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// Link is one step of propagation between two nodes
+type Link struct {
+	Source string   `json:"source"`
+	Target string   `json:"target"`
+	Kind   string   `json:"kind"` // assign, param, return, store or sink
+	Types  []string `json:"types"`
+}
+
+func NewGraph() *Graph {
+	return &Graph{Directed: true, nodes: map[string]*Node{}, links: map[string]*Link{}}
+}
+
+// Graphs holds the graph of each sink, or of the whole scan, for -graph
+type Graphs struct {
+	mu     sync.Mutex
+	Merge  bool
+	Scan   *Graph
+	Sinks  []*Graph
+	bySink map[string]*Graph
+}
+
+func NewGraphs(merge bool) *Graphs {
+	return &Graphs{Merge: merge, Scan: NewGraph(), bySink: map[string]*Graph{}}
+}
+
+// Add puts a finding in the graph of its sink, so that every source reaching
+// the same sink ends up in one graph. It reports whether the sink is new
+func (gs *Graphs) Add(result Result) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if gs.Merge {
+		return gs.Scan.Add(result)
+	}
+	key := nodeID(result.Filename, result.Vertex, "")
+	g, ok := gs.bySink[key]
+	if !ok {
+		g = NewGraph()
+		gs.bySink[key] = g
+		gs.Sinks = append(gs.Sinks, g)
+	}
+	g.Add(result)
+	return !ok
+}
+
+// All is the graphs to print, one per sink or a single one for the scan
+func (gs *Graphs) All() []*Graph {
+	if gs.Merge {
+		return []*Graph{gs.Scan}
+	}
+	return gs.Sinks
+}
+
+// Add walks a finding's taint back to its source, adding what is missing.
+// It reports whether the sink was new to the graph
+func (g *Graph) Add(result Result) bool {
+	sink, isNew := g.node(result.Filename, result.Vertex, "sink", sinkName(result.Stack))
+
+	from := sink
+	kind := "sink"
+	taint := result.LastTaint
+	for {
+		var to *Node
+		file := result.Filename
+		if taint.Filename != "" {
+			file = taint.Filename
+		}
+		if taint.Vertex == nil {
+			to, _ = g.node(file, nil, "source", taint.Name)
+		} else {
+			to, _ = g.node(file, taint.Vertex, "taint", taint.Name)
+		}
+		g.link(to, from, kind, result.Type)
+
+		if taint.Vertex == nil || taint.Parent == nil {
+			return isNew
+		}
+		kind = linkKind(taint)
+		from = to
+		taint = *taint.Parent
+	}
+}
+
+func (g *Graph) node(file string, v ast.Vertex, kind string, label string) (*Node, bool) {
+	id := nodeID(file, v, label)
+	if n, ok := g.nodes[id]; ok {
+		return n, false
+	}
+	n := &Node{ID: id, Kind: kind, Label: label, File: file}
+	if v != nil && v.GetPosition() != nil {
+		n.Line = v.GetPosition().StartLine
+		n.Pos = v.GetPosition().StartPos
+		n.Code = Code(v)
+	}
+	g.nodes[id] = n
+	g.Nodes = append(g.Nodes, n)
+	return n, true
+}
+
+func (g *Graph) link(from *Node, to *Node, kind string, t string) {
+	id := from.ID + "->" + to.ID
+	if l, ok := g.links[id]; ok {
+		if !inList(l.Types, t) {
+			l.Types = append(l.Types, t)
+		}
+		return
+	}
+	l := &Link{Source: from.ID, Target: to.ID, Kind: kind, Types: []string{t}}
+	g.links[id] = l
+	g.Links = append(g.Links, l)
+}
+
+// nodeID names a node by where it is, sources by file and name since they have no vertex
+func nodeID(file string, v ast.Vertex, label string) string {
+	if v == nil || v.GetPosition() == nil {
+		return file + ":" + label
+	}
+	pos := v.GetPosition()
+	if label == "" {
+		return fmt.Sprintf("%s:%d", file, pos.StartPos)
+	}
+	return fmt.Sprintf("%s:%d:%s", file, pos.StartPos, label)
+}
+
+// linkKind tells how a taint was set from its parent,
+// the traverser pushes returns and parameters as assigns
+func linkKind(taint Taint) string {
+	switch taint.Vertex.(type) {
+	case *ast.StmtReturn:
+		return "return"
+	case *ast.ExprFunctionCall, *ast.ExprMethodCall, *ast.ExprStaticCall:
+		if taint.Filename != "" {
+			return "store"
+		}
+		if taint.Scope.Class == "*" && taint.Scope.Block != "*" {
+			return "param"
+		}
+	}
+	return "assign"
+}
+
+// sinkName reads the innermost sink of a stack, [assign] $x <- [sink] fopen <- ...
+func sinkName(stack string) string {
+	i := strings.LastIndex(stack, "[sink] ")
+	if i < 0 {
+		return strings.SplitN(stack, " <- ", 2)[0]
+	}
+	return strings.SplitN(stack[i+len("[sink] "):], " <- ", 2)[0]
+}
+
+// DOT renders the graph for Graphviz
+func (g *Graph) DOT() string {
+	b := &strings.Builder{}
+	b.WriteString("digraph taint {\n\trankdir=LR;\n\tnode [fontname=\"monospace\"];\n")
+	for _, n := range g.Nodes {
+		shape := "box"
+		switch n.Kind {
+		case "source":
+			shape = "ellipse"
+		case "sink":
+			shape = "octagon"
+		}
+		label := n.Label
+		if n.Line > 0 {
+			label += fmt.Sprintf("\n%s:%d", n.File, n.Line)
+		}
+		if n.Code != "" {
+			label += "\n" + n.Code
+		}
+		fmt.Fprintf(b, "\t%s [shape=%s, label=%s];\n", dotQuote(n.ID), shape, dotQuote(label))
+	}
+	for _, l := range g.Links {
+		fmt.Fprintf(b, "\t%s -> %s [label=%s];\n", dotQuote(l.Source), dotQuote(l.Target), dotQuote(l.Kind+" "+strings.Join(l.Types, ",")))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func writeGraphs(gs *Graphs, format string) {
+	for _, g := range gs.All() {
+		if format == "dot" {
+			fmt.Println(g.DOT())
+			continue
+		}
+		str, err := g.JSON()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		fmt.Println(str)
+	}
+}
+
+func (g *Graph) JSON() (string, error) {
+	bytes, err := json.Marshal(g)
+	return string(bytes), err
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+// Code prints a vertex back to PHP on one line
+func Code(v ast.Vertex) string {
+	return strings.Join(strings.Fields(Fragment(v)), " ")
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+// graphOf adds the results of a type to the graphs of a scan
+func graphOf(t *testing.T, gs *Graphs, source string, typ string) {
+	t.Helper()
+	for _, r := range analyze(t, source) {
+		if r.Type == typ {
+			gs.Add(r)
+		}
+	}
+}
+
+func TestGraphFormats(t *testing.T) {
+	gs := NewGraphs(false)
+	graphOf(t, gs, "<?php\n$x = $_GET['a'];\necho $x;", "xss")
+	if len(gs.All()) != 1 {
+		t.Fatalf("%d graphs, want 1", len(gs.All()))
+	}
+	g := gs.All()[0]
+
+	dot := `digraph taint {
+	rankdir=LR;
+	node [fontname="monospace"];
+	"test.php:23:echo" [shape=octagon, label="echo\ntest.php:3\necho $x;"];
+	"test.php:6:$x" [shape=box, label="$x\ntest.php:2\n$x = $_GET['a']"];
+	"test.php:$_GET" [shape=ellipse, label="$_GET"];
+	"test.php:6:$x" -> "test.php:23:echo" [label="sink xss"];
+	"test.php:$_GET" -> "test.php:6:$x" [label="assign xss"];
+}`
+	if got := g.DOT(); got != dot {
+		t.Errorf("DOT\n%s\nwant\n%s", got, dot)
+	}
+
+	json := `{"directed":true,"nodes":[` +
+		`{"id":"test.php:23:echo","kind":"sink","label":"echo","file":"test.php","line":3,"pos":23,"code":"echo $x;"},` +
+		`{"id":"test.php:6:$x","kind":"taint","label":"$x","file":"test.php","line":2,"pos":6,"code":"$x = $_GET['a']"},` +
+		`{"id":"test.php:$_GET","kind":"source","label":"$_GET","file":"test.php"}],"links":[` +
+		`{"source":"test.php:6:$x","target":"test.php:23:echo","kind":"sink","types":["xss"]},` +
+		`{"source":"test.php:$_GET","target":"test.php:6:$x","kind":"assign","types":["xss"]}]}`
+	if got, err := g.JSON(); err != nil || got != json {
+		t.Errorf("JSON\n%s\nwant\n%s", got, json)
+	}
+}
+
+func TestGraphMerge(t *testing.T) {
+	source := "<?php\n$x = $_GET['a'];\necho $x;\nprint $x;"
+	tests := []struct {
+		merge  bool
+		graphs int
+		nodes  int
+	}{
+		// a graph per sink, each with the source and the assignment
+		{false, 2, 3},
+		// a graph for the scan, the source and assignment shared
+		{true, 1, 4},
+	}
+	for _, test := range tests {
+		gs := NewGraphs(test.merge)
+		graphOf(t, gs, source, "xss")
+		graphs := gs.All()
+		if len(graphs) != test.graphs {
+			t.Errorf("merge %v: %d graphs, want %d", test.merge, len(graphs), test.graphs)
+			continue
+		}
+		for _, g := range graphs {
+			if len(g.Nodes) != test.nodes {
+				t.Errorf("merge %v: %d nodes, want %d", test.merge, len(g.Nodes), test.nodes)
+			}
+		}
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,31 +10,69 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/VKCOM/noverify/src/php/parseutil"
 	"github.com/VKCOM/php-parser/pkg/ast"
-	"github.com/VKCOM/php-parser/pkg/visitor/printer"
 	"gopkg.in/yaml.v2"
 )
 
+// Version is the release of the analyzer
+const Version = "0.2.0"
+
 var (
 	Queue   = make(chan string)
 	Results = make(chan Result)
 	sm      sync.Map
 	Files   = 0
 	Vulns   = 0
+	Gadgets = 0
+
+	// the classes of every file, for -gadgets
+	Classes *GadgetFinder
+
+	// files that read from storage, scanned again once every write is known
+	Rescan   []Parsed
+	rescanMu sync.Mutex
 )
 
+// Parsed is a file reading stored data, to analyze again once every file has
+// stored what it does
+type Parsed struct {
+	Filename string
+	Keys     []string
+}
+
 type Result struct {
-	Vertex    ast.Vertex
-	Type      string
-	Code      string
-	Stack     string
-	LastTaint Taint
-	Filename  string
+	Vertex     ast.Vertex
+	Type       string
+	Code       string
+	Stack      string
+	LastTaint  Taint
+	Filename   string
+	CWE        int
+	Severity   string
+	Confidence string
+	Doubts     []string
+	Payload    string
+	Entry      *Entry
+}
+
+// Output holds the settings for writing results
+type Output struct {
+	Format        string
+	Report        *Report
+	MinSeverity   string
+	MinConfidence string
+	PoC           bool
+	BaseURL       string
+	WebRoot       string
+	Graphs        *Graphs
+	ContextBefore int
+	ContextAfter  int
 }
 
 func main() {
@@ -43,21 +80,226 @@ func main() {
 	threads := flag.Int("t", 100, "Number of goroutines to use")
 	datafile := flag.String("f", "data.yaml", "Specify a data file of sources, sinks, and filters")
 	fyaml := flag.Bool("yaml", false, "Output as YAML, (JSON by default)")
-	flag.Parse()
+	format := flag.String("format", "json", "Output format: json, yaml, or a single report once the scan is done: html, markdown, csv, checkstyle, junit or gitlab")
+	contextBefore := flag.Int("context-before", 2, "Source lines to show before each step of a finding")
+	contextAfter := flag.Int("context-after", 2, "Source lines to show after each step of a finding")
+	link := flag.String("link", "", "Link template for file:line in reports, e.g. https://github.com/org/repo/blob/main/{file}#L{line}")
+	gadgets := flag.Bool("gadgets", false, "Also list magic methods that reach dangerous operations (object injection gadgets)")
+	minSeverity := flag.String("min-severity", "info", "Only report findings of at least this severity (info, low, medium, high, critical)")
+	minConfidence := flag.String("min-confidence", "low", "Only report findings of at least this confidence (low, medium, high)")
+	poc := flag.Bool("poc", false, "Add a proof of concept request to each finding")
+	baseURL := flag.String("base-url", "http://localhost", "Base URL for proof of concept requests")
+	webroot := flag.String("webroot", ".", "Directory served at the base URL, for proof of concept requests")
+	backward := flag.Bool("backward", false, "Start at every sink and report those built from parameters, properties or globals, even with no source in sight")
+	graph := flag.String("graph", "", "Print the taint graph behind each sink instead, as dot or json")
+	graphMerge := flag.Bool("graph-merge", false, "With -graph, print a single graph for the whole scan")
+	surface := flag.Bool("surface", false, "List every request parameter read per endpoint and where it ends up, instead of findings")
+	diff := flag.String("diff", "", "Scan two revisions of a git repository, base..head, and report the findings on lines head changed, and those it fixed")
+	repo := flag.String("repo", ".", "The git repository for -diff")
+	corpus := flag.String("corpus", "", "Scan every subdirectory and archive of a directory as a project, printing the findings of each project together")
+	journal := flag.String("journal", "php-analyzer.journal", "Where -corpus records the projects done, to resume from after an interruption")
+	projects := flag.Int("projects", runtime.NumCPU(), "Number of projects -corpus scans at once")
+	projectTimeout := flag.Duration("project-timeout", 10*time.Minute, "How long -corpus lets a project scan before giving up on it")
+	totals := flag.String("totals", "", "Write the counts of the scan to this file as JSON when it is done, -corpus reads them from the scan of each project")
+	cacheDir := flag.String("cache", "", "Directory to keep what each file's scan found, so the next scan only analyzes the files that changed and those sharing storage with them")
+	explain := flag.String("explain", "", "Print every decision made about taints on file:line, instead of findings")
+	args, command := splitCommand(os.Args[1:])
+	flag.CommandLine.Parse(args)
+
+	if *fyaml {
+		*format = "yaml"
+	}
+	if !inList(Formats, *format) {
+		log.Fatalf("unknown format %q, use one of %s", *format, strings.Join(Formats, ", "))
+	}
+	if *graph != "" && !inList(GraphFormats, *graph) {
+		log.Fatalf("unknown graph format %q, use one of %s", *graph, strings.Join(GraphFormats, ", "))
+	}
+	if !inList(Severities, *minSeverity) {
+		log.Fatalf("unknown severity %q, use one of %s", *minSeverity, strings.Join(Severities, ", "))
+	}
+	if !inList(Confidences, *minConfidence) {
+		log.Fatalf("unknown confidence %q, use one of %s", *minConfidence, strings.Join(Confidences, ", "))
+	}
+	SurfaceMode = *surface
+	BackwardMode = *backward
+	if *explain != "" {
+		var err error
+		if Explaining, err = NewExplainer(*explain); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if (*diff != "" || command != "") && (*graph != "" || SurfaceMode || Explaining != nil) {
+		log.Fatal("-diff and compare can't be used with -graph, -surface or -explain")
+	}
+	if *corpus != "" && (*diff != "" || command != "" || *graph != "" || SurfaceMode || Explaining != nil || (*format != "json" && *format != "yaml")) {
+		log.Fatal("-corpus prints json or yaml, and can't be used with -diff, compare, -graph, -surface or -explain")
+	}
+	if *cacheDir != "" && (*diff != "" || command != "" || *graph != "" || SurfaceMode || Explaining != nil) {
+		log.Fatal("-cache can't be used with -diff, compare, -graph, -surface or -explain")
+	}
+	if command == "compare" && flag.NArg() != 2 {
+		log.Fatal("usage: php-analyzer compare [flags] old-dir new-dir")
+	}
 
 	t := time.Now()
 
 	defer func() {
+		if *gadgets {
+			log.Printf("Found %d gadgets", Gadgets)
+		}
 		log.Printf("Scanned %d files\tFound %d vulns\tIn time %v", Files, Vulns, time.Since(t))
+		if *totals != "" {
+			if err := writeTotals(*totals, Totals{Files: Files, Vulns: Vulns, Gadgets: Gadgets}); err != nil {
+				log.Println(err)
+			}
+		}
 	}()
 
-	go reader()
-	go workers(*depth, *threads, *datafile)
-	writer(*fyaml)
+	if *corpus != "" {
+		c, done, err := NewCorpus(*corpus, *journal, *projects, *projectTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := c.PassFlags(*datafile); err != nil {
+			log.Fatal(err)
+		}
+		total, failed := 0, 0
+		project := func(p Project) {
+			total++
+			Files += p.Files
+			Vulns += len(p.Findings)
+			if p.Error != "" {
+				failed++
+				log.Printf("%s failed: %s", p.Project, p.Error)
+			}
+			writeProject(p, *format)
+		}
+		for _, p := range done {
+			project(p)
+		}
+		if err := c.Run(project); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%d projects, %d from the journal, %d failed", total, len(done), failed)
+		return
+	}
+	if *diff == "" && command == "" && *cacheDir == "" {
+		go reader()
+		go workers(*depth, *threads, *datafile, *gadgets)
+	}
+	if SurfaceMode {
+		for range Results {
+		}
+		writeSurface(*format == "yaml")
+		return
+	}
+	if Explaining != nil {
+		for range Results {
+		}
+		writeExplanations(Explaining)
+		return
+	}
+	out := Output{
+		Format:        *format,
+		MinSeverity:   *minSeverity,
+		MinConfidence: *minConfidence,
+		PoC:           *poc,
+		BaseURL:       *baseURL,
+		WebRoot:       *webroot,
+		ContextBefore: *contextBefore,
+		ContextAfter:  *contextAfter,
+	}
+	if *diff != "" {
+		d, err := NewDiff(*repo, *diff)
+		if err != nil {
+			log.Fatal(err)
+		}
+		findings, err := d.Review(*depth, *threads, *datafile, *gadgets, out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if inList([]string{"checkstyle", "junit", "gitlab"}, *format) {
+			// CI fails on what is reported, which fixed findings shouldn't
+			var open []Finding
+			for _, f := range findings {
+				if f.Status != "fixed" {
+					open = append(open, f)
+				}
+			}
+			findings = open
+		}
+		Vulns = len(findings)
+		if *format != "json" && *format != "yaml" {
+			writeReport(&Report{Started: t, Findings: findings, Link: *link}, *format)
+			return
+		}
+		for _, f := range findings {
+			printFinding(f, *format)
+		}
+		return
+	}
+	if command == "compare" {
+		c, err := Compare(flag.Arg(0), flag.Arg(1), *depth, *threads, *datafile, *gadgets, out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		Vulns = len(c.Introduced)
+		if *format != "json" && *format != "yaml" {
+			writeReport(&Report{Started: t, Findings: c.Findings(), Guards: c.Guards, Link: *link}, *format)
+			return
+		}
+		writeComparison(c, *format)
+		return
+	}
+	if *cacheDir != "" {
+		c, err := NewCache(*cacheDir, *datafile, *depth, *gadgets, BackwardMode, *minSeverity, *minConfidence, *poc, *baseURL, *webroot, *contextBefore, *contextAfter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var names []string
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			names = append(names, inputNames(s.Text())...)
+		}
+		findings := c.Scan(names, *depth, *threads, *datafile, *gadgets, out)
+		Vulns = len(findings)
+		if *format != "json" && *format != "yaml" {
+			writeReport(&Report{Started: t, Findings: findings, Link: *link}, *format)
+			return
+		}
+		for _, f := range findings {
+			printFinding(f, *format)
+		}
+		return
+	}
+	if *graph != "" {
+		out.Graphs = NewGraphs(*graphMerge)
+	}
+	if *format != "json" && *format != "yaml" {
+		out.Report = &Report{Started: t, Link: *link}
+	}
+	writer(out)
+	if out.Graphs != nil {
+		writeGraphs(out.Graphs, *graph)
+	}
+	if out.Report != nil {
+		writeReport(out.Report, *format)
+	}
+
+}
 
+// splitCommand takes the command off the arguments, compare is a command of
+// its own and its flags come after it
+func splitCommand(args []string) ([]string, string) {
+	if len(args) > 0 && args[0] == "compare" {
+		return args[1:], args[0]
+	}
+	return args, ""
 }
 
-func worker(depth int, datafile string) {
+func worker(depth int, datafile string, gadgets bool) {
 
 	// recover from parseutil.ParseFie() panic on bad syntax
 
@@ -65,7 +307,7 @@ func worker(depth int, datafile string) {
 		defer func() {
 			if err := recover(); err != nil {
 				log.Println("RECOVERING:", err, "\tFILE:", filename)
-				worker(depth, datafile)
+				worker(depth, datafile, gadgets)
 			}
 		}()
 		// read the file
@@ -86,116 +328,325 @@ func worker(depth int, datafile string) {
 
 		// create visitor
 		a := NewAnalyzer(filename, datafile)
+		if BackwardMode {
+			NewSinkFinder(a).Find(root)
+			release(a)
+			continue
+		}
 		t := NewTraverser(a)
 		for j := 0; j < depth; j++ {
 			t.Traverse(root)
 		}
+
+		if len(a.ReadKeys) > 0 {
+			rescanMu.Lock()
+			Rescan = append(Rescan, Parsed{Filename: filename, Keys: a.ReadKeys})
+			rescanMu.Unlock()
+		}
+
+		// gadgets are reported once every class is known, the file's code with them
+		if gadgets && Classes.Add(root, filename) {
+			a.Reported = true
+		}
+		release(a)
+	}
+}
+
+// release frees a file read from memory once it is scanned, unless a finding
+// shows its code, a step of another file's finding may, or the rescan reads
+// it again
+func release(a *Analyzer) {
+	if !a.Reported && !a.Stores && len(a.ReadKeys) == 0 {
+		InMemory.Release(a.Filename)
 	}
 }
 
-func workers(depth int, n int, datafile string) {
+func workers(depth int, n int, datafile string, gadgets bool) {
+	if gadgets {
+		Classes = NewGadgetFinder(NewAnalyzer("", datafile).Data)
+	}
 	var wg sync.WaitGroup
 	for i := 0; i < n; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			worker(depth, datafile)
+			worker(depth, datafile, gadgets)
 		}()
 	}
 	wg.Wait()
+
+	// second order taint, writes to storage may have come from
+	// files scanned after the ones reading it back
+	if Stored.Len() > 0 {
+		rescan(depth, n, datafile)
+	}
+
+	if gadgets {
+		for _, g := range Classes.Find() {
+			Results <- Result{Vertex: g.Vertex, Type: "gadget", Filename: g.Filename, Stack: "[gadget] " + g.Class + "::" + strings.Join(g.Path, " -> "), Severity: "info", Confidence: "high"}
+		}
+	}
+
 	close(Results)
 }
 
+func rescan(depth int, n int, datafile string) {
+	queue := make(chan Parsed)
+	go func() {
+		for _, p := range Rescan {
+			// only files reading keys something was stored under
+			if Stored.Any(p.Keys) {
+				queue <- p
+			}
+		}
+		close(queue)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for p := range queue {
+				content, err := readFile(p.Filename)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				root, err := parseutil.ParseFile(content)
+				if err != nil {
+					log.Println(err, p.Filename)
+					continue
+				}
+				a := NewAnalyzer(p.Filename, datafile)
+				t := NewTraverser(a)
+				for j := 0; j < depth; j++ {
+					t.Traverse(root)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// reader queues the files named on stdin, once their annotations are read
 func reader() {
+	var names []string
 	s := bufio.NewScanner(os.Stdin)
 	for s.Scan() {
-		filename := s.Text()
-
-		Queue <- filename
+		names = append(names, inputNames(s.Text())...)
+	}
+	Annotated = ProjectAnnotations(names)
+	for _, name := range names {
+		Queue <- name
 	}
 	close(Queue)
 }
 
-func writer(fyaml bool) {
+// inputNames are the files a line of input names, archives are scanned from
+// memory, by the archive name and the paths inside them
+func inputNames(filename string) []string {
+	if !IsArchive(filename) {
+		return []string{filename}
+	}
+	files, err := Unpack(filename)
+	if err != nil {
+		log.Println(err, filename)
+		return nil
+	}
+	return InMemory.AddArchive(filename, files)
+}
+
+func writer(out Output) {
 	for result := range Results {
 		defer func() {
 			if err := recover(); err != nil {
-				writer(fyaml)
+				writer(out)
 			}
 		}()
 
-		type tt struct {
-			Stack string
-			Code  string
+		if Rank(Severities, result.Severity) < Rank(Severities, out.MinSeverity) ||
+			Rank(Confidences, result.Confidence) < Rank(Confidences, out.MinConfidence) {
+			continue
 		}
-		var taintPath []tt
-		var reversed []tt
-		taint := result.LastTaint
 
-		o := bytes.NewBufferString("")
-		//f := formatter.NewFormatter().WithState(formatter.FormatterStatePHP)
-		//result.Vertex.Accept(f)
-		p := printer.NewPrinter(o).WithState(printer.PrinterStatePHP)
-		result.Vertex.Accept(p)
-		code := fmt.Sprintf("%s %d:%d", strings.TrimSpace(o.String()), result.Vertex.GetPosition().StartLine, result.Vertex.GetPosition().StartPos)
-
-		type output struct {
-			File string
-			Type string
-			Path []tt
+		// graphs are printed once every path to a sink is in
+		if out.Graphs != nil {
+			if out.Graphs.Add(result) && result.Type != "gadget" {
+				Vulns++
+			}
+			continue
 		}
 
-		taintPath = append(taintPath, tt{Code: code, Stack: result.Stack})
-		for taint.Vertex != nil {
-			o := bytes.NewBufferString("")
-			//d := dumper.NewDumper(o)
-			p := printer.NewPrinter(o).WithState(printer.PrinterStatePHP)
-			//result.Vertex.Accept(f)
-			taint.Vertex.Accept(p)
-			taintstring := fmt.Sprintf("%s %d:%d", strings.TrimSpace(o.String()), taint.Vertex.GetPosition().StartLine, taint.Vertex.GetPosition().StartPos)
-			taintPath = append(taintPath, tt{Code: taintstring, Stack: taint.Stack})
+		finding := NewFinding(result, out)
 
-			taint = *taint.Parent
-		}
+		if isUnique(finding.Key) {
+			if result.Type == "gadget" {
+				Gadgets++
+			} else {
+				Vulns++
+			}
 
-		for i := len(taintPath) - 1; i >= 0; i-- {
-			reversed = append(reversed, taintPath[i])
+			// reports are written once the scan is done
+			if out.Report != nil {
+				out.Report.Findings = append(out.Report.Findings, finding)
+				continue
+			}
+
+			printFinding(finding, out.Format)
 		}
+	}
+}
 
-		var (
-			bytes []byte
-			err   error
-		)
+// printFinding writes a finding on its own, as JSON or YAML
+func printFinding(finding Finding, format string) {
+	var (
+		bytes []byte
+		err   error
+	)
+	if format == "yaml" {
+		bytes, err = yaml.Marshal(finding)
+	} else {
+		bytes, err = json.Marshal(finding)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	fmt.Println(string(bytes))
+}
 
-		if !(fyaml) {
-			bytes, err = json.Marshal(output{
-				File: result.Filename,
-				Type: result.Type,
-				Path: reversed,
-			})
-			if err != nil {
-				log.Println(err)
-			}
-		} else {
-			bytes, err = yaml.Marshal(output{
-				File: result.Filename,
-				Type: result.Type,
-				Path: reversed,
-			})
-			if err != nil {
-				log.Println(err)
-			}
+// scan runs a whole scan of the given files and returns its findings, for modes
+// that scan more than once and work on the findings afterwards
+func scan(names []string, depth int, threads int, datafile string, gadgets bool, out Output) []Finding {
+	return scanPart(names, ProjectAnnotations(names), depth, threads, datafile, gadgets, out)
+}
+
+// scanPart scans some of a project's files, with the annotations all of them declare
+func scanPart(names []string, annotations []Annotation, depth int, threads int, datafile string, gadgets bool, out Output) []Finding {
+	Queue = make(chan string)
+	Results = make(chan Result)
+	sm = sync.Map{}
+	Stored = &Storage{taints: make(map[string][]Taint)}
+	Rescan = nil
+	// nothing read by an earlier scan is kept, files may have changed since
+	SourceFiles = &FileCache{files: make(map[string][]byte)}
+	Surface = &Inventory{reads: make(map[string]*Read)}
+	InMemory.Keep(names)
+	Annotated = annotations
+
+	go func() {
+		for _, name := range names {
+			Queue <- name
 		}
+		close(Queue)
+	}()
+	go workers(depth, threads, datafile, gadgets)
+
+	out.Report = &Report{}
+	writer(out)
+	return out.Report.Findings
+}
 
-		if isUnique(code) {
-			Vulns++
-			fmt.Println(string(bytes))
+// Step is one step of a finding's path, Code is the vertex printed back,
+// Snippet the source lines around it and EndColumn one past its last character
+type Step struct {
+	Stack     string
+	Code      string
+	File      string `json:",omitempty" yaml:",omitempty"`
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+	Snippet   []SnippetLine `json:",omitempty" yaml:",omitempty"`
+
+	// the file the step is in, and its byte offsets there
+	Filename string `json:"-" yaml:"-"`
+	Start    int    `json:"-" yaml:"-"`
+	End      int    `json:"-" yaml:"-"`
+}
+
+// Finding is a result as it is written out
+type Finding struct {
+	File       string
+	Type       string
+	CWE        int `json:",omitempty" yaml:",omitempty"`
+	Severity   string
+	Confidence string
+	Doubts     []string `json:",omitempty" yaml:",omitempty"`
+	Path       []Step
+	PoC        *PoC `json:",omitempty" yaml:",omitempty"`
+	// new, fixed or persisting, when two scans are compared
+	Status string `json:",omitempty" yaml:",omitempty"`
+	// the archive the file was read from, File is its path inside it
+	Archive string `json:",omitempty" yaml:",omitempty"`
+
+	// the sink and vuln type, which findings are told apart by
+	Key string `json:"-" yaml:"-"`
+}
+
+func NewFinding(result Result, out Output) Finding {
+	var poc *PoC
+	if out.PoC {
+		poc = NewPoC(result, result.Payload, out.BaseURL, out.WebRoot)
+	}
+
+	var path []Step
+	path = append(path, newStep(result.Vertex, result.Stack, result.Filename))
+	taint := result.LastTaint
+	// a stored taint names the file that stored it, the steps before are in it too
+	filename := result.Filename
+	for taint.Vertex != nil {
+		if taint.Filename != "" {
+			filename = taint.Filename
+		}
+		step := newStep(taint.Vertex, taint.Stack, filename)
+		if filename != result.Filename {
+			_, step.File = InMemory.Archive(filename)
 		}
+		path = append(path, step)
+
+		taint = *taint.Parent
+	}
+
+	var reversed []Step
+	for i := len(path) - 1; i >= 0; i-- {
+		Locate(&path[i], out.ContextBefore, out.ContextAfter)
+		reversed = append(reversed, path[i])
+	}
+
+	archive, file := InMemory.Archive(result.Filename)
+	return Finding{
+		File:       file,
+		Archive:    archive,
+		Type:       result.Type,
+		CWE:        result.CWE,
+		Severity:   result.Severity,
+		Confidence: result.Confidence,
+		Doubts:     result.Doubts,
+		Path:       reversed,
+		PoC:        poc,
+		Key:        fmt.Sprintf("%s:%d:%s", path[0].Filename, path[0].Start, result.Type),
+	}
+}
+
+func newStep(v ast.Vertex, stack string, filename string) Step {
+	pos := v.GetPosition()
+	return Step{
+		Stack:    stack,
+		Code:     Fragment(v),
+		Filename: filename,
+		Line:     pos.StartLine,
+		Start:    pos.StartPos,
+		End:      pos.EndPos,
 	}
 }
 
 func readFile(filename string) ([]byte, error) {
+	if content, ok := InMemory.Get(filename); ok {
+		return content, nil
+	}
 	if strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://") {
 		return download(filename)
 	} else {
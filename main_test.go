@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// scanSource scans a PHP file and returns its findings
+func scanSource(t *testing.T, source string) []Finding {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.php")
+	if err := os.WriteFile(name, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return scan([]string{name}, 10, 4, "data.yaml", false, Output{MinSeverity: "info", MinConfidence: "low"})
+}
+
+// hasFinding reports whether a finding of a type has its sink on a line
+func hasFinding(findings []Finding, typ string, line int) bool {
+	for _, f := range findings {
+		if f.Type == typ && f.Path[len(f.Path)-1].Line == line {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFindingKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		line   int
+		types  []string
+	}{
+		{
+			"redirect header",
+			"<?php\nheader(\"Location: \" . $_GET['a']);",
+			2, []string{"open-redirect", "header-injection"},
+		},
+		{
+			"file written from path and content",
+			"<?php\nfile_put_contents($_GET['p'], $_GET['c']);",
+			2, []string{"file-write", "file-write-content"},
+		},
+		{
+			"url read",
+			"<?php\n$x = file_get_contents($_GET['u']);",
+			2, []string{"ssrf", "lfd"},
+		},
+	}
+	for _, test := range tests {
+		findings := scanSource(t, test.source)
+		for _, typ := range test.types {
+			if !hasFinding(findings, typ, test.line) {
+				t.Errorf("%s: no %s finding on line %d", test.name, typ, test.line)
+			}
+		}
+	}
+}
+
+func TestScanForgetsEarlierScans(t *testing.T) {
+	SurfaceMode = true
+	defer func() { SurfaceMode = false }()
+
+	InMemory.Add("rescan/a.php", []byte("<?php\necho $_GET['a'];"))
+	InMemory.Add("rescan/b.php", []byte("<?php\n$b = $_POST['b'];"))
+	out := Output{MinSeverity: "info", MinConfidence: "low"}
+	if findings := scan([]string{"rescan/a.php"}, 10, 4, "data.yaml", false, out); !hasFinding(findings, "xss", 2) {
+		t.Fatal("no xss finding in the first scan")
+	}
+	if _, ok := InMemory.Get("rescan/b.php"); ok {
+		t.Error("a file the scan doesn't read is still held")
+	}
+	if endpoints := Surface.Endpoints(); len(endpoints) != 1 || endpoints[0].File != "rescan/a.php" {
+		t.Errorf("endpoints %+v, want those of a.php", endpoints)
+	}
+
+	// a scan of another file lets go of a.php, which then comes back changed
+	InMemory.Add("rescan/c.php", []byte("<?php\necho 'c';"))
+	scan([]string{"rescan/c.php"}, 10, 4, "data.yaml", false, out)
+	if !InMemory.Add("rescan/a.php", []byte("<?php\n\n\necho $_GET['a'];")) {
+		t.Fatal("a.php is still held after a scan that doesn't read it")
+	}
+	findings := scan([]string{"rescan/a.php"}, 10, 4, "data.yaml", false, out)
+	if !hasFinding(findings, "xss", 4) || hasFinding(findings, "xss", 2) {
+		t.Fatalf("findings %+v, want xss on line 4 only", findings)
+	}
+	if sink := findings[0].Path[len(findings[0].Path)-1]; len(sink.Snippet) == 0 || sink.Snippet[len(sink.Snippet)-1].Code != "echo $_GET['a'];" {
+		t.Errorf("snippet %+v read from the earlier version", sink.Snippet)
+	}
+	if endpoints := Surface.Endpoints(); len(endpoints) != 1 || len(endpoints[0].Params) != 1 || endpoints[0].Params[0].Line != 4 {
+		t.Errorf("endpoints %+v, want the read on line 4 only", endpoints)
+	}
+}
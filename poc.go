@@ -0,0 +1,298 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+)
+
+// Entry is a WordPress entry point registered by a file
+type Entry struct {
+	Kind     string // ajax, admin, rest or file
+	Name     string // action, page slug or route
+	Callback string
+}
+
+// PoC is a request that should trigger a finding
+type PoC struct {
+	Method  string
+	URL     string
+	Params  []Param
+	Payload string
+	Curl    string
+}
+
+// Param is where a source reads from the request
+type Param struct {
+	In   string // query, body, cookie, header or file
+	Name string
+}
+
+// EntryFor picks the entry point whose callback holds the scope of a finding,
+// nil when none is known to
+func (a *Analyzer) EntryFor(scope Context) *Entry {
+	if scope.Block == "" {
+		// top level code runs when the file is requested
+		return &Entry{Kind: "file"}
+	}
+	for i, e := range a.Entries {
+		if e.Callback == scope.Block {
+			return &a.Entries[i]
+		}
+	}
+	// a function no entry point is known to reach
+	return nil
+}
+
+// EntryFinder visitor collects add_action, add_*_page and register_rest_route calls
+type EntryFinder struct {
+	visitor.Null
+	Entries []Entry
+}
+
+func FindEntries(n ast.Vertex) []Entry {
+	ef := &EntryFinder{}
+	n.Accept(traverser.NewTraverser(ef))
+	return ef.Entries
+}
+
+func (ef *EntryFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	name := NameString(n.Function)
+	switch {
+	case name == "add_action":
+		hook, _ := LiteralArg(n, 0)
+		for _, prefix := range []string{"wp_ajax_nopriv_", "wp_ajax_"} {
+			if strings.HasPrefix(hook, prefix) {
+				ef.Entries = append(ef.Entries, Entry{Kind: "ajax", Name: hook[len(prefix):], Callback: CallbackName(Arg(n, 1))})
+				break
+			}
+		}
+	case name == "add_menu_page" || (strings.HasPrefix(name, "add_") && strings.HasSuffix(name, "_page") && name != "add_submenu_page"):
+		slug, _ := LiteralArg(n, 3)
+		ef.Entries = append(ef.Entries, Entry{Kind: "admin", Name: slug, Callback: CallbackName(Arg(n, 4))})
+	case name == "add_submenu_page":
+		slug, _ := LiteralArg(n, 4)
+		ef.Entries = append(ef.Entries, Entry{Kind: "admin", Name: slug, Callback: CallbackName(Arg(n, 5))})
+	case name == "register_rest_route":
+		ns, _ := LiteralArg(n, 0)
+		route, _ := LiteralArg(n, 1)
+		callback := ""
+		if args, ok := Arg(n, 2).(*ast.ExprArray); ok {
+			for _, nn := range args.Items {
+				item, ok := nn.(*ast.ExprArrayItem)
+				if !ok {
+					continue
+				}
+				if key, ok := item.Key.(*ast.ScalarString); ok && Unquote(string(key.Value)) == "callback" {
+					callback = CallbackName(item.Val)
+				}
+			}
+		}
+		ef.Entries = append(ef.Entries, Entry{Kind: "rest", Name: strings.Trim(ns, "/") + "/" + strings.TrimLeft(route, "/"), Callback: callback})
+	}
+}
+
+// CallbackName reads 'function' and array($this, 'method') callbacks
+func CallbackName(n ast.Vertex) string {
+	switch cb := n.(type) {
+	case *ast.ScalarString:
+		return Unquote(string(cb.Value))
+	case *ast.ExprArray:
+		if len(cb.Items) == 2 {
+			if item, ok := cb.Items[1].(*ast.ExprArrayItem); ok {
+				if method, ok := item.Val.(*ast.ScalarString); ok {
+					return Unquote(string(method.Value))
+				}
+			}
+		}
+	}
+	return ""
+}
+
+var routeParam = regexp.MustCompile(`\(\?P<\w+>[^)]*\)`)
+
+// NewPoC builds a request for a finding from the sources at the root of its trace
+func NewPoC(result Result, payload string, baseURL string, webroot string) *PoC {
+	// the earliest step is where the source was read
+	taint := result.LastTaint
+	first := result.Vertex
+	for taint.Vertex != nil {
+		first = taint.Vertex
+		taint = *taint.Parent
+	}
+
+	params := SourceParams(first, taint.Name)
+	if len(params) == 0 || result.Entry == nil {
+		return nil
+	}
+
+	poc := &PoC{Method: "GET", Payload: payload, Params: params}
+	for _, p := range params {
+		if p.In == "body" || p.In == "file" {
+			poc.Method = "POST"
+		}
+	}
+
+	base := strings.TrimRight(baseURL, "/")
+	query := url.Values{}
+	entry := result.Entry
+	switch {
+	case entry.Kind == "ajax":
+		poc.URL = base + "/wp-admin/admin-ajax.php"
+		query.Set("action", entry.Name)
+	case entry.Kind == "admin":
+		poc.URL = base + "/wp-admin/admin.php"
+		query.Set("page", entry.Name)
+	case entry.Kind == "rest":
+		poc.URL = base + "/wp-json/" + routeParam.ReplaceAllString(entry.Name, "1")
+	case strings.HasPrefix(result.Filename, "http://") || strings.HasPrefix(result.Filename, "https://"):
+		poc.URL = result.Filename
+	default:
+		rel, err := filepath.Rel(webroot, result.Filename)
+		if err != nil {
+			rel = result.Filename
+		}
+		poc.URL = base + "/" + filepath.ToSlash(rel)
+	}
+
+	body := url.Values{}
+	curl := []string{"curl"}
+	for _, p := range params {
+		switch p.In {
+		case "query":
+			query.Set(p.Name, payload)
+		case "body":
+			body.Set(p.Name, payload)
+		case "cookie":
+			curl = append(curl, "-b", shellQuote(p.Name+"="+url.QueryEscape(payload)))
+		case "header":
+			curl = append(curl, "-H", shellQuote(p.Name+": "+payload))
+		case "file":
+			curl = append(curl, "-F", shellQuote(p.Name+"=@poc.txt;filename="+payload))
+		}
+	}
+	// ajax actions read the action from the body as well
+	if poc.Method == "POST" && entry.Kind == "ajax" {
+		query.Del("action")
+		body.Set("action", entry.Name)
+	}
+
+	target := poc.URL
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	if len(body) > 0 {
+		curl = append(curl, "--data", shellQuote(body.Encode()))
+	}
+	poc.URL = target
+	poc.Curl = strings.Join(append(curl, shellQuote(target)), " ")
+	return poc
+}
+
+// SourceParams finds the request parameters a source is read from inside a vertex
+func SourceParams(n ast.Vertex, source string) []Param {
+	sf := &sourceFinder{source: source, names: map[string]bool{}}
+	n.Accept(traverser.NewTraverser(sf))
+	return sf.params()
+}
+
+func (sf *sourceFinder) params() []Param {
+	// $_GET['a']['b'] is visited as a[b] and a, keep the longest
+	var names []string
+	for name := range sf.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []Param
+	for i, name := range names {
+		if i+1 < len(names) && strings.HasPrefix(names[i+1], name+"[") {
+			continue
+		}
+		if p, ok := sourceParam(sf.source, name); ok {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+func sourceParam(source string, name string) (Param, bool) {
+	switch source {
+	case "$_GET", "$_REQUEST":
+		return Param{In: "query", Name: name}, true
+	case "$_POST":
+		return Param{In: "body", Name: name}, true
+	case "$_COOKIE":
+		return Param{In: "cookie", Name: name}, true
+	case "$_FILES":
+		if i := strings.Index(name, "["); i >= 0 {
+			name = name[:i]
+		}
+		return Param{In: "file", Name: name}, true
+	case "$_SERVER":
+		if strings.HasPrefix(name, "HTTP_") {
+			words := strings.Split(strings.ToLower(name[5:]), "_")
+			for i, w := range words {
+				words[i] = capitalize(w)
+			}
+			return Param{In: "header", Name: strings.Join(words, "-")}, true
+		}
+	}
+	return Param{}, false
+}
+
+// sourceFinder visitor collects the keys a superglobal is indexed with,
+// only at one read of it when at is set
+type sourceFinder struct {
+	visitor.Null
+	source string
+	at     ast.Vertex
+	names  map[string]bool
+}
+
+func (sf *sourceFinder) ExprArrayDimFetch(n *ast.ExprArrayDimFetch) {
+	var keys []string
+	var cur ast.Vertex = n
+	for {
+		fetch, ok := cur.(*ast.ExprArrayDimFetch)
+		if !ok {
+			break
+		}
+		key, ok := fetch.Dim.(*ast.ScalarString)
+		if !ok {
+			return
+		}
+		keys = append([]string{Unquote(string(key.Value))}, keys...)
+		cur = fetch.Var
+	}
+
+	variable, ok := cur.(*ast.ExprVariable)
+	if !ok || NameString(variable.Name) != sf.source || (sf.at != nil && cur != sf.at) {
+		return
+	}
+
+	name := keys[0]
+	for _, k := range keys[1:] {
+		name += "[" + k + "]"
+	}
+	sf.names[name] = true
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// capitalize upper-cases the first letter of a word, levels and header names
+// are ASCII
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
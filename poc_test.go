@@ -0,0 +1,137 @@
+package main
+
+import "testing"
+
+func TestPoCPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		typ     string
+		payload string
+	}{
+		{"tag body", "<?php\necho '<div>' . $_GET['a'];", "xss", "<script>alert(document.domain)</script>"},
+		{"single-quoted attribute", "<?php\necho \"<a href='\" . $_GET['a'] . \"'>\";", "xss", "'><script>alert(document.domain)</script>"},
+		{"unknown markup", "<?php\necho $_GET['a'];", "xss", "\"><script>alert(document.domain)</script>"},
+		{"quoted sql", "<?php\n$wpdb->get_results(\"SELECT * FROM t WHERE a = '\" . $_GET['a'] . \"'\");", "sqli", "1' AND SLEEP(5)-- -"},
+		{"escaped sql built in a variable", "<?php\n$sql = 'SELECT * FROM t WHERE a = ' . esc_sql($_GET['a']);\n$wpdb->get_results($sql);", "sqli", "1 AND SLEEP(5)-- -"},
+		{"function no entry point reaches", "<?php\nfunction f() { echo $_GET['a']; }", "xss", ""},
+	}
+	for _, test := range tests {
+		var found []Result
+		for _, r := range analyze(t, test.source) {
+			if r.Type == test.typ {
+				found = append(found, r)
+			}
+		}
+		if len(found) == 0 {
+			t.Errorf("%s: no %s finding", test.name, test.typ)
+			continue
+		}
+		payload := ""
+		if poc := NewPoC(found[0], found[0].Payload, "http://localhost", "."); poc != nil {
+			payload = poc.Payload
+		}
+		if payload != test.payload {
+			t.Errorf("%s: payload %q, want %q", test.name, payload, test.payload)
+		}
+	}
+}
+
+func TestPoCRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		typ    string
+		method string
+		url    string
+		curl   string
+	}{
+		{
+			"file requested directly",
+			"<?php\necho $_GET['a'];",
+			"xss", "GET", "http://localhost/test.php?a=%3Cx%27%3E",
+			"curl 'http://localhost/test.php?a=%3Cx%27%3E'",
+		},
+		{
+			"ajax action posted to",
+			"<?php\nadd_action('wp_ajax_save', 'save');\nfunction save() { echo $_POST['a']; }",
+			"csrf", "POST", "http://localhost/wp-admin/admin-ajax.php",
+			"curl --data 'a=%3Cx%27%3E&action=save' 'http://localhost/wp-admin/admin-ajax.php'",
+		},
+		{
+			"ajax action read from the query",
+			"<?php\nadd_action('wp_ajax_nopriv_load', 'load');\nfunction load() { echo $_GET['a']; }",
+			"xss", "GET", "http://localhost/wp-admin/admin-ajax.php?a=%3Cx%27%3E&action=load",
+			"curl 'http://localhost/wp-admin/admin-ajax.php?a=%3Cx%27%3E&action=load'",
+		},
+		{
+			"admin page",
+			"<?php\nadd_menu_page('T', 'T', 'manage_options', 'my-page', 'render');\nfunction render() { echo $_GET['a']; }",
+			"xss", "GET", "http://localhost/wp-admin/admin.php?a=%3Cx%27%3E&page=my-page",
+			"curl 'http://localhost/wp-admin/admin.php?a=%3Cx%27%3E&page=my-page'",
+		},
+		{
+			"admin subpage",
+			"<?php\nadd_submenu_page('tools.php', 'T', 'T', 'manage_options', 'sub', 'render');\nfunction render() { echo $_GET['a']; }",
+			"xss", "GET", "http://localhost/wp-admin/admin.php?a=%3Cx%27%3E&page=sub",
+			"curl 'http://localhost/wp-admin/admin.php?a=%3Cx%27%3E&page=sub'",
+		},
+		{
+			"rest route with a parameter",
+			"<?php\nregister_rest_route('shop/v1', '/items/(?P<id>\\d+)', array('callback' => 'items'));\nfunction items() { echo $_GET['a']; }",
+			"xss", "GET", "http://localhost/wp-json/shop/v1/items/1?a=%3Cx%27%3E",
+			"curl 'http://localhost/wp-json/shop/v1/items/1?a=%3Cx%27%3E'",
+		},
+		{
+			"cookie",
+			"<?php\necho $_COOKIE['c'];",
+			"csrf", "GET", "http://localhost/test.php",
+			"curl -b 'c=%3Cx%27%3E' 'http://localhost/test.php'",
+		},
+		{
+			"header",
+			"<?php\necho $_SERVER['HTTP_USER_AGENT'];",
+			"csrf", "GET", "http://localhost/test.php",
+			`curl -H 'User-Agent: <x'\''>' 'http://localhost/test.php'`,
+		},
+		{
+			"uploaded file",
+			"<?php\nsystem($_FILES['f']['name']);",
+			"rce", "POST", "http://localhost/test.php",
+			`curl -F 'f=@poc.txt;filename=<x'\''>' 'http://localhost/test.php'`,
+		},
+	}
+	for _, test := range tests {
+		var poc *PoC
+		for _, r := range analyze(t, test.source) {
+			if r.Type == test.typ {
+				poc = NewPoC(r, "<x'>", "http://localhost/", ".")
+				break
+			}
+		}
+		if poc == nil {
+			t.Errorf("%s: no request for a %s finding", test.name, test.typ)
+			continue
+		}
+		if poc.Method != test.method || poc.URL != test.url || poc.Curl != test.curl {
+			t.Errorf("%s: %s %s, %s\nwant %s %s, %s", test.name, poc.Method, poc.URL, poc.Curl, test.method, test.url, test.curl)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"plain", "'plain'"},
+		{"", "''"},
+		{"it's", `'it'\''s'`},
+		{"$(id) `id`", "'$(id) `id`'"},
+	}
+	for _, test := range tests {
+		if got := shellQuote(test.s); got != test.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", test.s, got, test.want)
+		}
+	}
+}
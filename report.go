@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formats are the values -format takes, json and yaml print a finding per line,
+// the others are a report written once the scan is done
+var Formats = []string{"json", "yaml", "html", "markdown", "csv", "checkstyle", "junit", "gitlab"}
+
+// Report holds every finding of a scan, for formats written at the end
+type Report struct {
+	Started  time.Time
+	Findings []Finding
+	// guards a new version added, when two versions are compared
+	Guards []Guard
+	// Link makes file:line into a link, {file} and {line} are filled in
+	Link string
+}
+
+func writeReport(r *Report, format string) {
+	r.Sort()
+	var err error
+	switch format {
+	case "html":
+		err = r.HTML(os.Stdout)
+	case "markdown":
+		err = r.Markdown(os.Stdout)
+	case "csv":
+		err = r.CSV(os.Stdout)
+	case "checkstyle":
+		err = r.Checkstyle(os.Stdout)
+	case "junit":
+		err = r.JUnit(os.Stdout)
+	case "gitlab":
+		err = r.GitLab(os.Stdout)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Sort orders findings by file, sink line and type, workers finish files in
+// no particular order
+func (r *Report) Sort() {
+	sort.SliceStable(r.Findings, func(i, j int) bool {
+		a, b := r.Findings[i], r.Findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if la, lb := a.Path[len(a.Path)-1].Line, b.Path[len(b.Path)-1].Line; la != lb {
+			return la < lb
+		}
+		return a.Type < b.Type
+	})
+}
+
+// file reads a scanned file again, for the code around findings
+func (r *Report) file(name string) []byte {
+	return SourceFiles.Read(name)
+}
+
+// Count is a row of the summary
+type Count struct {
+	Name  string
+	Count int
+}
+
+// counts tallies findings by a key, most common first
+func (r *Report) counts(key func(Finding) string) []Count {
+	m := map[string]int{}
+	for _, f := range r.Findings {
+		m[key(f)]++
+	}
+	var counts []Count
+	for name, n := range m {
+		counts = append(counts, Count{Name: name, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts
+}
+
+// Source is the part of a file around the steps of a finding
+type Source struct {
+	File  string
+	Lines []SourceLine
+}
+
+type SourceLine struct {
+	Number int
+	Text   string
+	HTML   template.HTML
+	Gap    bool // lines were left out before this one
+}
+
+// Sources cuts the files of a finding down to its steps, each step marked at its
+// position. Files that can't be read again, dropped by a later scan, are shown
+// from the snippets of their steps
+func (r *Report) Sources(f Finding) []Source {
+	var names []string
+	steps := map[string][]int{}
+	for i, step := range f.Path {
+		if _, ok := steps[step.Filename]; !ok {
+			names = append(names, step.Filename)
+		}
+		steps[step.Filename] = append(steps[step.Filename], i)
+	}
+
+	var sources []Source
+	for _, name := range names {
+		content := r.file(name)
+		if content == nil {
+			if source, ok := snippetSource(name, f, steps[name]); ok {
+				sources = append(sources, source)
+			}
+			continue
+		}
+
+		// which step each byte belongs to, the narrowest step wins where they nest
+		marks := make([]int, len(content))
+		width := make([]int, len(content))
+		for _, i := range steps[name] {
+			step := f.Path[i]
+			for b := step.Start; b < step.End && b < len(content); b++ {
+				if marks[b] == 0 || step.End-step.Start < width[b] {
+					marks[b] = i + 1
+					width[b] = step.End - step.Start
+				}
+			}
+		}
+
+		// the lines of each step's snippet, as many around it as -context-before and -context-after ask
+		lines := strings.SplitAfter(string(content), "\n")
+		show := make([]bool, len(lines)+1)
+		for _, i := range steps[name] {
+			for _, l := range f.Path[i].Snippet {
+				if l.Line >= 1 && l.Line <= len(lines) {
+					show[l.Line] = true
+				}
+			}
+		}
+
+		_, file := InMemory.Archive(name)
+		source := Source{File: file}
+		offset := 0
+		gap := false
+		for n, line := range lines {
+			number := n + 1
+			if !show[number] {
+				gap = true
+				offset += len(line)
+				continue
+			}
+			source.Lines = append(source.Lines, SourceLine{Number: number, Text: strings.TrimRight(line, "\r\n"), HTML: markLine(line, marks[offset:offset+len(line)]), Gap: gap && len(source.Lines) > 0})
+			gap = false
+			offset += len(line)
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// snippetSource puts together the snippets of a file's steps, unmarked
+func snippetSource(name string, f Finding, steps []int) (Source, bool) {
+	code := map[int]string{}
+	var numbers []int
+	for _, i := range steps {
+		for _, l := range f.Path[i].Snippet {
+			if _, ok := code[l.Line]; !ok {
+				numbers = append(numbers, l.Line)
+			}
+			code[l.Line] = l.Code
+		}
+	}
+	if len(numbers) == 0 {
+		return Source{}, false
+	}
+	sort.Ints(numbers)
+
+	_, file := InMemory.Archive(name)
+	source := Source{File: file}
+	for i, number := range numbers {
+		text := code[number]
+		source.Lines = append(source.Lines, SourceLine{Number: number, Text: text, HTML: markLine(text, make([]int, len(text))), Gap: i > 0 && number != numbers[i-1]+1})
+	}
+	return source, true
+}
+
+// markLine escapes a line of source, wrapping the runs that belong to a step
+func markLine(line string, marks []int) template.HTML {
+	line = strings.TrimRight(line, "\r\n")
+	b := &strings.Builder{}
+	for start := 0; start < len(line); {
+		end := start
+		for end < len(line) && marks[end] == marks[start] {
+			end++
+		}
+		text := html.EscapeString(line[start:end])
+		if marks[start] > 0 {
+			fmt.Fprintf(b, `<mark class="step" title="step %d">%s</mark>`, marks[start], text)
+		} else {
+			b.WriteString(text)
+		}
+		start = end
+	}
+	return template.HTML(b.String())
+}
+
+// StepFile names the file of a step as findings do, files read from an
+// archive by their path in it
+func StepFile(step Step) string {
+	if step.File != "" {
+		return step.File
+	}
+	_, file := InMemory.Archive(step.Filename)
+	return file
+}
+
+// Location is file:line of a step, a link when the report has a template
+func (r *Report) Location(step Step, markdown bool) string {
+	file := StepFile(step)
+	loc := fmt.Sprintf("%s:%d", file, step.Line)
+	if r.Link == "" || !markdown {
+		return loc
+	}
+	url := strings.NewReplacer("{file}", file, "{line}", fmt.Sprint(step.Line)).Replace(r.Link)
+	return "[" + loc + "](" + url + ")"
+}
+
+// HTML writes the report as a single page that needs nothing else to be viewed
+func (r *Report) HTML(w io.Writer) error {
+	type item struct {
+		Index   int
+		Finding Finding
+		Sources []Source
+	}
+	var items []item
+	for i, f := range r.Findings {
+		items = append(items, item{Index: i + 1, Finding: f, Sources: r.Sources(f)})
+	}
+
+	return reportTemplate.Execute(w, map[string]interface{}{
+		"Started":    r.Started.Format(time.RFC1123),
+		"Total":      len(r.Findings),
+		"Types":      r.counts(func(f Finding) string { return f.Type }),
+		"Severities": r.counts(func(f Finding) string { return f.Severity }),
+		"Files":      r.counts(func(f Finding) string { return f.File }),
+		"Findings":   items,
+		"Guards":     r.Guards,
+	})
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>php-analyzer report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0; }
+.summary { display: flex; gap: 2em; flex-wrap: wrap; margin: 1em 0; }
+.summary table { border-collapse: collapse; }
+.summary td, .summary th { padding: 2px 8px; text-align: left; border-bottom: 1px solid #ddd; }
+.filters { margin: 1em 0; display: flex; gap: 1em; }
+.finding { border: 1px solid #ccc; border-radius: 4px; margin: 1em 0; padding: 0 1em 1em; }
+.badge { display: inline-block; padding: 1px 6px; border-radius: 3px; background: #eee; font-size: 0.9em; }
+.critical { background: #7a0000; color: #fff; } .high { background: #d32f2f; color: #fff; }
+.medium { background: #f57c00; color: #fff; } .low { background: #fbc02d; } .info { background: #90caf9; }
+ol.path li { font-family: monospace; margin: 2px 0; }
+pre { background: #f7f7f7; padding: 0.5em; overflow-x: auto; }
+pre .num { color: #999; display: inline-block; width: 4em; user-select: none; }
+pre .gap { color: #999; }
+mark.step { background: #ffe082; }
+</style>
+</head>
+<body>
+<h1>php-analyzer report</h1>
+<p>{{.Total}} findings, scan started {{.Started}}</p>
+
+<div class="summary">
+<table><tr><th>Type</th><th></th></tr>{{range .Types}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+<table><tr><th>Severity</th><th></th></tr>{{range .Severities}}<tr><td><span class="badge {{.Name}}">{{.Name}}</span></td><td>{{.Count}}</td></tr>{{end}}</table>
+<table><tr><th>File</th><th></th></tr>{{range .Files}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+</div>
+
+{{if .Guards}}<h2>Added guards</h2>
+<div class="summary"><table><tr><th>File</th><th>Function</th><th>Kind</th><th>Call</th><th>Added</th><th>Lines</th></tr>
+{{range .Guards}}<tr><td>{{.File}}</td><td>{{.Function}}</td><td>{{.Kind}}</td><td>{{.Call}}</td><td>{{.Added}}</td><td>{{range $i, $l := .Lines}}{{if $i}}, {{end}}{{$l}}{{end}}</td></tr>
+{{end}}</table></div>{{end}}
+
+<div class="filters">
+<select id="type"><option value="">all types</option>{{range .Types}}<option>{{.Name}}</option>{{end}}</select>
+<select id="severity"><option value="">all severities</option>{{range .Severities}}<option>{{.Name}}</option>{{end}}</select>
+<input id="search" type="search" placeholder="file or code">
+</div>
+
+{{range .Findings}}
+<div class="finding" data-type="{{.Finding.Type}}" data-severity="{{.Finding.Severity}}">
+<h3>#{{.Index}} {{.Finding.Type}} <span class="badge {{.Finding.Severity}}">{{.Finding.Severity}}</span> <span class="badge">{{.Finding.Confidence}} confidence</span>{{if .Finding.Status}} <span class="badge">{{.Finding.Status}}</span>{{end}}{{if .Finding.CWE}} <span class="badge">CWE-{{.Finding.CWE}}</span>{{end}}</h3>
+<div>{{.Finding.File}}{{if .Finding.Archive}} in {{.Finding.Archive}}{{end}}</div>
+{{if .Finding.Doubts}}<p>Doubts: {{range $i, $d := .Finding.Doubts}}{{if $i}}, {{end}}{{$d}}{{end}}</p>{{end}}
+<ol class="path">{{range .Finding.Path}}<li>{{.Stack}}{{if .File}} ({{.File}}){{end}}</li>{{end}}</ol>
+{{range .Sources}}<div>{{.File}}</div>
+<pre>{{range .Lines}}{{if .Gap}}<span class="gap">…</span>
+{{end}}<span class="num">{{.Number}}</span>{{.HTML}}
+{{end}}</pre>{{end}}
+{{if .Finding.PoC}}<pre>{{.Finding.PoC.Curl}}</pre>{{end}}
+</div>
+{{end}}
+
+<script>
+function filter() {
+	var type = document.getElementById("type").value;
+	var severity = document.getElementById("severity").value;
+	var search = document.getElementById("search").value.toLowerCase();
+	document.querySelectorAll(".finding").forEach(function (f) {
+		var show = (!type || f.dataset.type == type) &&
+			(!severity || f.dataset.severity == severity) &&
+			(!search || f.textContent.toLowerCase().indexOf(search) >= 0);
+		f.style.display = show ? "" : "none";
+	});
+}
+["type", "severity", "search"].forEach(function (id) {
+	document.getElementById(id).addEventListener("input", filter);
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReportLocation(t *testing.T) {
+	InMemory.AddArchive("location.zip", []ArchiveFile{{Name: "inc/a.php", Content: []byte("<?php")}})
+	r := &Report{Link: "https://example.com/{file}#L{line}"}
+	tests := []struct {
+		step Step
+		want string
+	}{
+		{Step{Filename: "a.php", Line: 3}, "[a.php:3](https://example.com/a.php#L3)"},
+		{Step{Filename: "location.zip/inc/a.php", Line: 3}, "[inc/a.php:3](https://example.com/inc/a.php#L3)"},
+		{Step{Filename: "location.zip/inc/a.php", File: "inc/a.php", Line: 3}, "[inc/a.php:3](https://example.com/inc/a.php#L3)"},
+	}
+	for _, test := range tests {
+		if got := r.Location(test.step, true); got != test.want {
+			t.Errorf("Location of %s = %s, want %s", test.step.Filename, got, test.want)
+		}
+	}
+}
+
+func TestReportSources(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.php")
+	source := "<?php\n$a = 1;\n$b = 2;\n$x = $_GET['x'];\n$c = 3;\n$d = 4;\n$e = 5;\necho $x;\n"
+	if err := os.WriteFile(name, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		before int
+		after  int
+		want   []int
+	}{
+		{0, 0, []int{4, 8}},
+		{1, 0, []int{3, 4, 7, 8}},
+		{2, 2, []int{2, 3, 4, 5, 6, 7, 8}},
+	}
+	for _, test := range tests {
+		read, sink := strings.Index(source, "$x ="), strings.Index(source, "echo")
+		path := []Step{
+			{Filename: name, Line: 4, Start: read, End: read + len("$x = $_GET['x']")},
+			{Filename: name, Line: 8, Start: sink, End: sink + len("echo $x;")},
+		}
+		for i := range path {
+			Locate(&path[i], test.before, test.after)
+		}
+		sources := (&Report{}).Sources(Finding{Path: path})
+		if len(sources) != 1 {
+			t.Fatalf("%d sources, want 1", len(sources))
+		}
+		var lines []int
+		for _, l := range sources[0].Lines {
+			lines = append(lines, l.Number)
+		}
+		if !reflect.DeepEqual(lines, test.want) {
+			t.Errorf("with %d before and %d after, lines %v, want %v", test.before, test.after, lines, test.want)
+		}
+	}
+}
+
+func TestReportSourcesFromSnippets(t *testing.T) {
+	// a file dropped by a later scan, as the base of -diff is by the scan of head
+	path := []Step{
+		{Filename: "gone/a.php", Line: 4, Snippet: []SnippetLine{{Line: 3, Code: "$b = 2;"}, {Line: 4, Code: "$x = $_GET['x'];"}}},
+		{Filename: "gone/a.php", Line: 8, Snippet: []SnippetLine{{Line: 7, Code: "$e = 5;"}, {Line: 8, Code: "echo $x;"}}},
+	}
+	sources := (&Report{}).Sources(Finding{Path: path})
+	if len(sources) != 1 {
+		t.Fatalf("%d sources, want 1", len(sources))
+	}
+	want := []SourceLine{
+		{Number: 3, Text: "$b = 2;", HTML: "$b = 2;"},
+		{Number: 4, Text: "$x = $_GET['x'];", HTML: "$x = $_GET[&#39;x&#39;];"},
+		{Number: 7, Text: "$e = 5;", HTML: "$e = 5;", Gap: true},
+		{Number: 8, Text: "echo $x;", HTML: "echo $x;"},
+	}
+	if got := sources[0].Lines; !reflect.DeepEqual(got, want) {
+		t.Errorf("lines %+v, want %+v", got, want)
+	}
+}
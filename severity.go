@@ -0,0 +1,61 @@
+package main
+
+import "github.com/VKCOM/php-parser/pkg/ast"
+
+// levels from least to most
+var (
+	Severities  = []string{"info", "low", "medium", "high", "critical"}
+	Confidences = []string{"low", "medium", "high"}
+)
+
+// DefaultSeverity is the severity of vulns data.yaml doesn't rate
+const DefaultSeverity = "medium"
+
+// Level is the severity a vuln is reported with
+func (v Vuln) Level() string {
+	if v.Severity == "" {
+		return DefaultSeverity
+	}
+	return v.Severity
+}
+
+// Rank finds a level in its scale, unknown levels rank lowest
+func Rank(levels []string, level string) int {
+	for i, l := range levels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// Confidence scores a path by how many guesses it took to follow
+func Confidence(doubts []string) string {
+	switch {
+	case len(doubts) == 0:
+		return "high"
+	case len(doubts) <= 2:
+		return "medium"
+	}
+	return "low"
+}
+
+func IsDynamicCall(n ast.Vertex) bool {
+	switch call := n.(type) {
+	case *ast.ExprFunctionCall:
+		_, ok := call.Function.(*ast.Name)
+		return !ok
+	case *ast.ExprMethodCall:
+		_, ok := call.Method.(*ast.Identifier)
+		return !ok
+	case *ast.ExprStaticCall:
+		_, ok := call.Call.(*ast.Identifier)
+		return !ok
+	}
+	return false
+}
+
+// With appends to a copy, so taints sharing a history don't share changes
+func With(list []string, s string) []string {
+	return append(append([]string{}, list...), s)
+}
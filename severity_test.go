@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		typ        string
+		line       int
+		cwe        int
+		severity   string
+		confidence string
+	}{
+		{"command injection", "<?php\nsystem($_GET['x']);", "rce", 2, 78, "critical", "high"},
+		{"sql injection", "<?php\nmysql_query($_GET['x']);", "sqli", 2, 89, "high", "high"},
+		{"reflected xss", "<?php\necho $_GET['x'];", "xss", 2, 79, "medium", "high"},
+		{"sink called dynamically", "<?php\n$fn = 'system';\n$fn($_GET['x']);", "rce", 3, 78, "critical", "medium"},
+	}
+	for _, test := range tests {
+		r, ok := reported(analyze(t, test.source), test.typ, test.line)
+		if !ok {
+			t.Errorf("%s: no %s finding", test.name, test.typ)
+			continue
+		}
+		if r.CWE != test.cwe || r.Severity != test.severity || r.Confidence != test.confidence {
+			t.Errorf("%s: CWE-%d %s with %s confidence, want CWE-%d %s with %s", test.name, r.CWE, r.Severity, r.Confidence, test.cwe, test.severity, test.confidence)
+		}
+	}
+}
+
+func TestSeverityLevels(t *testing.T) {
+	if got := (Vuln{}).Level(); got != DefaultSeverity {
+		t.Errorf("unrated vuln is %s, want %s", got, DefaultSeverity)
+	}
+	if got := (Vuln{Severity: "low"}).Level(); got != "low" {
+		t.Errorf("low vuln is %s, want low", got)
+	}
+	if Rank(Severities, "critical") <= Rank(Severities, "high") || Rank(Severities, "bogus") != -1 {
+		t.Error("severities out of order")
+	}
+	for doubts, want := range map[int]string{0: "high", 1: "medium", 2: "medium", 3: "low"} {
+		if got := Confidence(make([]string, doubts)); got != want {
+			t.Errorf("%d doubts give %s confidence, want %s", doubts, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor/printer"
+)
+
+// SourceFiles keeps the files findings are in, to show the code around them
+var SourceFiles = &FileCache{files: make(map[string][]byte)}
+
+type FileCache struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// Read reads a file once, nil if it can't be read
+func (fc *FileCache) Read(name string) []byte {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	content, ok := fc.files[name]
+	if !ok {
+		var err error
+		content, err = readFile(name)
+		if err != nil {
+			log.Println(err)
+		}
+		fc.files[name] = content
+	}
+	return content
+}
+
+// SnippetLine is a line of the original source around a step
+type SnippetLine struct {
+	Line int
+	Code string
+}
+
+// Locate fills in where a step is as lines and columns, columns count from 1,
+// and the source lines around it
+func Locate(step *Step, before int, after int) {
+	step.Column, step.EndLine, step.EndColumn = 1, step.Line, 1
+	content := SourceFiles.Read(step.Filename)
+	if content == nil || step.Start < 0 || step.End > len(content) || step.Start > step.End {
+		return
+	}
+
+	column := func(offset int) int {
+		return offset - bytes.LastIndexByte(content[:offset], '\n')
+	}
+	step.Column = column(step.Start)
+	step.EndLine = step.Line + bytes.Count(content[step.Start:step.End], []byte("\n"))
+	step.EndColumn = column(step.End)
+
+	// a newline ends the last line rather than starting another
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	for l := step.Line - before; l <= step.EndLine+after; l++ {
+		if l >= 1 && l <= len(lines) {
+			step.Snippet = append(step.Snippet, SnippetLine{Line: l, Code: strings.TrimRight(lines[l-1], "\r")})
+		}
+	}
+}
+
+// Fragment prints a vertex back to PHP, without the comments and open and
+// close tags the parser attaches around it
+func Fragment(v ast.Vertex) string {
+	o := bytes.NewBufferString("")
+	p := printer.NewPrinter(o).WithState(printer.PrinterStatePHP)
+	v.Accept(p)
+
+	code := strings.TrimSpace(o.String())
+	code = strings.TrimSpace(strings.TrimSuffix(code, "?>"))
+	for {
+		switch {
+		case strings.HasPrefix(code, "<?php"):
+			code = code[len("<?php"):]
+		case strings.HasPrefix(code, "?>"):
+			code = code[len("?>"):]
+		case strings.HasPrefix(code, "//") || strings.HasPrefix(code, "#"):
+			i := strings.IndexByte(code, '\n')
+			if i < 0 {
+				return ""
+			}
+			code = code[i+1:]
+		case strings.HasPrefix(code, "/*"):
+			i := strings.Index(code, "*/")
+			if i < 0 {
+				return ""
+			}
+			code = code[i+2:]
+		default:
+			return code
+		}
+		code = strings.TrimSpace(code)
+	}
+}
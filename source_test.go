@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/VKCOM/noverify/src/php/parseutil"
+)
+
+func TestFragment(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"<?php\nheader($x);", "header($x);"},
+		{"<?php echo $x ?>", "echo $x"},
+		{"<?php\n// set it\n$a = $b;", "$a = $b;"},
+		{"<?php\n/* set it */ $a = $b;", "$a = $b;"},
+	}
+	for _, test := range tests {
+		root, err := parseutil.ParseFile([]byte(test.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Fragment(root.Stmts[0]); got != test.want {
+			t.Errorf("Fragment of %q = %q, want %q", test.source, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+)
+
+// Stored holds taints written to persistent storage by any file,
+// keyed by storage family and key, e.g. option:my_setting
+var Stored = &Storage{taints: make(map[string][]Taint)}
+
+type Storage struct {
+	mu     sync.Mutex
+	taints map[string][]Taint
+}
+
+func (s *Storage) Store(key string, taint Taint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.taints[key] {
+		if t.Type == taint.Type {
+			return
+		}
+	}
+	s.taints[key] = append(s.taints[key], taint)
+}
+
+func (s *Storage) Load(key string) []Taint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Taint{}, s.taints[key]...)
+}
+
+// Any reports whether a taint is stored under any of the keys
+func (s *Storage) Any(keys []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if len(s.taints[key]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.taints)
+}
+
+// TableFunctions are the $wpdb methods whose key is a table, the one written
+// to or the one a query reads from
+var TableFunctions = []string{"insert", "update", "replace", "get_var", "get_row", "get_results", "get_col"}
+
+// FileFunctions are the functions whose key is the directory of a path
+var FileFunctions = []string{"move_uploaded_file", "file_put_contents", "file_get_contents", "file"}
+
+// StorageKey names a key within the family of a storage function,
+// so that update_post_meta and get_post_meta share keys but get_option does not
+func StorageKey(function string, key string) string {
+	switch {
+	case inList(TableFunctions, function):
+		return "table:" + key
+	case inList(FileFunctions, function):
+		return "file:" + key
+	case function == "$_SESSION":
+		return "session:" + key
+	}
+
+	family := function
+	for _, prefix := range []string{"update_", "add_", "set_", "get_"} {
+		if strings.HasPrefix(family, prefix) {
+			family = family[len(prefix):]
+			break
+		}
+	}
+	return family + ":" + key
+}
+
+// StorageArg returns the key a storage function is passed as its i'th argument,
+// false unless the key is known from the literals of the code
+func StorageArg(function string, call ast.Vertex, i int) (string, bool) {
+	switch {
+	case inList(TableFunctions, function):
+		return TableName(function, Arg(call, i))
+	case inList(FileFunctions, function):
+		return PathDir(Arg(call, i))
+	}
+	return LiteralArg(call, i)
+}
+
+var (
+	sqlTable    = regexp.MustCompile("(?i)\\b(?:from|join)\\s+`?(\\w+)")
+	sqlKeywords = []string{"where", "join", "left", "inner", "order", "group", "limit", "select"}
+)
+
+// TableName names the table written to, or read from by a query, without
+// the prefix the table name is usually built from
+func TableName(function string, n ast.Vertex) (string, bool) {
+	// $wpdb->get_var($wpdb->prepare("SELECT ..."))
+	if call, ok := n.(*ast.ExprMethodCall); ok && NameString(call.Method) == "prepare" {
+		n = Arg(call, 0)
+	}
+	text := LiteralText(n)
+
+	table := ""
+	if strings.HasPrefix(function, "get_") {
+		if m := sqlTable.FindStringSubmatch(text); m != nil {
+			table = m[1]
+		}
+	} else {
+		table = strings.Trim(text, " `")
+	}
+	table = strings.TrimPrefix(strings.ToLower(table), "wp_")
+	if table == "" || inList(sqlKeywords, table) || strings.ContainsAny(table, " .") {
+		return "", false
+	}
+	return table, true
+}
+
+// PathDir is the directory of a path from the literals it is built of,
+// "uploads/" . $name is in uploads
+func PathDir(n ast.Vertex) (string, bool) {
+	text := LiteralText(n)
+	i := strings.LastIndex(text, "/")
+	if i < 0 {
+		return "", false
+	}
+	dir := strings.Trim(text[:i], "./")
+	return dir, dir != ""
+}
+
+// SessionKey returns the key of a $_SESSION entry if it is a string literal
+func SessionKey(n *ast.ExprArrayDimFetch) (string, bool) {
+	key := RequestKey(n)
+	if !strings.HasPrefix(key, "$_SESSION[") {
+		return "", false
+	}
+	return key[len("$_SESSION[") : len(key)-1], true
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, l := range list {
+		if l == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VKCOM/noverify/src/php/parseutil"
+	"github.com/VKCOM/php-parser/pkg/ast"
+)
+
+func TestStorageChannels(t *testing.T) {
+	tests := []struct {
+		name  string
+		write string
+		read  string
+		typ   string
+		line  int
+		want  bool
+	}{
+		{
+			"option",
+			"<?php\nupdate_option('opt', $_GET['cmd']);",
+			"<?php\nsystem(get_option('opt'));",
+			"rce", 2, true,
+		},
+		{
+			"another option",
+			"<?php\nupdate_option('opt', $_GET['cmd']);",
+			"<?php\nsystem(get_option('other'));",
+			"rce", 2, false,
+		},
+		{
+			"table",
+			"<?php\n$wpdb->insert($wpdb->prefix . 'notes', array('body' => $_POST['body']));",
+			"<?php\n$n = $wpdb->get_var(\"SELECT body FROM {$wpdb->prefix}notes WHERE id = 1\");\necho $n;",
+			"csrf", 3, true,
+		},
+		{
+			"session",
+			"<?php\n$_SESSION['name'] = $_POST['name'];",
+			"<?php\necho $_SESSION['name'];",
+			"csrf", 2, true,
+		},
+		{
+			"uploaded file",
+			"<?php\nmove_uploaded_file($_FILES['f']['tmp_name'], 'uploads/' . basename($_FILES['f']['name']));",
+			"<?php\nsystem(file_get_contents('uploads/' . $f));",
+			"rce", 2, true,
+		},
+	}
+	for _, test := range tests {
+		Stored = &Storage{taints: make(map[string][]Taint)}
+		Rescan = nil
+
+		dir := t.TempDir()
+		read, write := filepath.Join(dir, "read.php"), filepath.Join(dir, "write.php")
+		if err := os.WriteFile(read, []byte(test.read), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(write, []byte(test.write), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// the read is scanned first, the write only reaches it on the rescan
+		Queue = make(chan string)
+		Results = make(chan Result)
+		go func() {
+			Queue <- read
+			Queue <- write
+			close(Queue)
+		}()
+		go workers(10, 1, "data.yaml", false)
+
+		var results []Result
+		for r := range Results {
+			if r.Filename == read {
+				results = append(results, r)
+			}
+		}
+		if _, got := reported(results, test.typ, test.line); got != test.want {
+			t.Errorf("%s: reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStoredSteps(t *testing.T) {
+	InMemory.Add("steps/write.php", []byte("<?php\n$x = $_GET['c'];\nupdate_option('steps', $x);"))
+	InMemory.Add("steps/read.php", []byte("<?php\nsystem(get_option('steps'));"))
+	findings := scan([]string{"steps/write.php", "steps/read.php"}, 10, 1, "data.yaml", false, Output{MinSeverity: "info", MinConfidence: "low"})
+
+	for _, f := range findings {
+		if f.File != "steps/read.php" || f.Type != "rce" {
+			continue
+		}
+		// the assignment and the store were made in the writing file
+		for _, step := range f.Path[:2] {
+			if step.File != "steps/write.php" || len(step.Snippet) == 0 || step.Snippet[0].Code != step.Code+";" {
+				t.Errorf("step %s is shown from %q, want steps/write.php", step.Stack, step.File)
+			}
+		}
+		return
+	}
+	t.Errorf("no rce finding in steps/read.php")
+}
+
+func TestStorageArg(t *testing.T) {
+	tests := []struct {
+		function string
+		source   string
+		key      string
+		ok       bool
+	}{
+		{"get_option", "<?php get_option('opt');", "opt", true},
+		{"get_option", "<?php get_option($name);", "", false},
+		{"insert", "<?php $wpdb->insert($wpdb->prefix . 'notes', $data);", "notes", true},
+		{"insert", "<?php $wpdb->insert('wp_notes', $data);", "notes", true},
+		{"insert", "<?php $wpdb->insert($table, $data);", "", false},
+		{"get_row", "<?php $wpdb->get_row(\"SELECT * FROM `{$wpdb->prefix}notes` WHERE id = 1\");", "notes", true},
+		{"get_row", "<?php $wpdb->get_row($wpdb->prepare(\"SELECT * FROM {$wpdb->prefix}notes WHERE id = %d\", $id));", "notes", true},
+		{"get_row", "<?php $wpdb->get_row(\"SELECT * FROM $table WHERE id = 1\");", "", false},
+		{"file_get_contents", "<?php file_get_contents(WP_CONTENT_DIR . '/uploads/' . $f);", "uploads", true},
+		{"file_get_contents", "<?php file_get_contents($dir . '/' . $f);", "", false},
+	}
+	for _, test := range tests {
+		root, err := parseutil.ParseFile([]byte(test.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		call := root.Stmts[0].(*ast.StmtExpression).Expr
+		if key, ok := StorageArg(test.function, call, 0); key != test.key || ok != test.ok {
+			t.Errorf("StorageArg(%s) of %q = %q, %v, want %q, %v", test.function, test.source, key, ok, test.key, test.ok)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/VKCOM/php-parser/pkg/ast"
+	"github.com/VKCOM/php-parser/pkg/visitor"
+	"github.com/VKCOM/php-parser/pkg/visitor/traverser"
+	"gopkg.in/yaml.v2"
+)
+
+// Surface collects every read of a source in -surface mode
+var (
+	Surface     = &Inventory{reads: make(map[string]*Read)}
+	SurfaceMode bool
+)
+
+type Inventory struct {
+	mu    sync.Mutex
+	reads map[string]*Read
+}
+
+// Read is one place user input enters the code, and what became of it
+type Read struct {
+	File     string
+	Endpoint string
+	Source   string
+	Params   []Param
+	Line     int
+	Flows    []string
+	Ends     []string
+}
+
+// Touch records what the engine decided for a taint, against the read it started from
+func (a *Analyzer) Touch(taint Taint, item Item, at ast.Vertex, flows bool) {
+	if !SurfaceMode || taint.Type != "surface" {
+		return
+	}
+
+	read := taint.ReadAt(at)
+	if read == nil || read.GetPosition() == nil || item.Vertex == read {
+		// read back from storage, or the helper call reading the request
+		return
+	}
+	source := taint.Name
+	for t := &taint; t != nil; t = t.Parent {
+		source = t.Name
+	}
+
+	event := item.Type + " " + item.Name
+	switch item.Type {
+	case "store":
+		event = "store " + item.Key
+	case "break":
+		event = "condition"
+	}
+
+	pos := read.GetPosition()
+	key := fmt.Sprintf("%s:%d:%s", a.Filename, pos.StartPos, source)
+
+	Surface.mu.Lock()
+	defer Surface.mu.Unlock()
+
+	r, ok := Surface.reads[key]
+	if !ok {
+		endpoint := a.Filename
+		if entry := a.EntryFor(a.CurrentContext); entry != nil && entry.Kind != "file" {
+			endpoint = entry.Kind + ":" + entry.Name
+		}
+		// the outermost item around the read holds its keys, the read itself
+		// when it is in nothing
+		within := read
+		if len(a.CallStack) > 0 {
+			within = a.CallStack[len(a.CallStack)-1].Vertex
+		}
+		r = &Read{File: a.Filename, Endpoint: endpoint, Source: source, Params: ReadParams(within, read, source), Line: pos.StartLine}
+		Surface.reads[key] = r
+	}
+	if flows {
+		r.Flows = appendUnique(r.Flows, event)
+	} else {
+		r.Ends = appendUnique(r.Ends, event)
+	}
+}
+
+// ReadParams names the parameters one read of a source is made with, the keys a
+// superglobal is indexed with or the literal argument of a helper like $request->get_param('id')
+func ReadParams(within ast.Vertex, read ast.Vertex, source string) []Param {
+	if strings.HasPrefix(source, "$") {
+		sf := &sourceFinder{source: source, at: read, names: map[string]bool{}}
+		within.Accept(traverser.NewTraverser(sf))
+		return sf.params()
+	}
+
+	hf := &helperFinder{name: source}
+	read.Accept(traverser.NewTraverser(hf))
+	return hf.params
+}
+
+// helperFinder visitor collects the literal arguments of calls to a function or method
+type helperFinder struct {
+	visitor.Null
+	name   string
+	params []Param
+}
+
+func (hf *helperFinder) add(n ast.Vertex) {
+	// filter_input(INPUT_GET, 'name')
+	i := 0
+	if hf.name == "filter_input" {
+		i = 1
+	}
+	if name, ok := LiteralArg(n, i); ok {
+		hf.params = append(hf.params, Param{In: "request", Name: name})
+	}
+}
+
+func (hf *helperFinder) ExprFunctionCall(n *ast.ExprFunctionCall) {
+	if NameString(n.Function) == hf.name {
+		hf.add(n)
+	}
+}
+
+func (hf *helperFinder) ExprMethodCall(n *ast.ExprMethodCall) {
+	if NameString(n.Method) == hf.name {
+		hf.add(n)
+	}
+}
+
+// Endpoint groups the reads of one file and entry point
+type Endpoint struct {
+	File     string
+	Endpoint string
+	Params   []SurfaceParam
+}
+
+type SurfaceParam struct {
+	Source string
+	Name   string `json:",omitempty" yaml:",omitempty"`
+	In     string `json:",omitempty" yaml:",omitempty"`
+	Line   int
+	Flows  []string `json:",omitempty" yaml:",omitempty"`
+	Ends   []string `json:",omitempty" yaml:",omitempty"`
+}
+
+// Endpoints flattens the inventory into a table per endpoint
+func (inv *Inventory) Endpoints() []Endpoint {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	groups := map[string]*Endpoint{}
+	var keys []string
+	for _, read := range inv.reads {
+		key := read.File + "\x00" + read.Endpoint
+		group, ok := groups[key]
+		if !ok {
+			group = &Endpoint{File: read.File, Endpoint: read.Endpoint}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+
+		params := read.Params
+		if len(params) == 0 {
+			// the whole superglobal, or a key that isn't a literal
+			params = []Param{{}}
+		}
+		for _, p := range params {
+			group.Params = append(group.Params, SurfaceParam{Source: read.Source, Name: p.Name, In: p.In, Line: read.Line, Flows: read.Flows, Ends: read.Ends})
+		}
+	}
+	sort.Strings(keys)
+
+	var endpoints []Endpoint
+	for _, key := range keys {
+		group := groups[key]
+		sort.SliceStable(group.Params, func(i, j int) bool {
+			return group.Params[i].Line < group.Params[j].Line
+		})
+		endpoints = append(endpoints, *group)
+	}
+	return endpoints
+}
+
+func writeSurface(fyaml bool) {
+	for _, endpoint := range Surface.Endpoints() {
+		var (
+			bytes []byte
+			err   error
+		)
+		if fyaml {
+			bytes, err = yaml.Marshal(endpoint)
+		} else {
+			bytes, err = json.Marshal(endpoint)
+		}
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		fmt.Println(string(bytes))
+	}
+}
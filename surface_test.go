@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VKCOM/noverify/src/php/parseutil"
+	"github.com/VKCOM/php-parser/pkg/ast"
+)
+
+func TestSurface(t *testing.T) {
+	SurfaceMode, Surface = true, &Inventory{reads: make(map[string]*Read)}
+	defer func() { SurfaceMode = false }()
+
+	analyze(t, "<?php\n$id = $_GET['id'];\necho $id;\nfunction save() {\nupdate_option('n', intval($_POST['n']));\n}\nadd_action('wp_ajax_save', 'save');")
+	want := []Endpoint{
+		{File: "test.php", Endpoint: "ajax:save", Params: []SurfaceParam{
+			{Source: "$_POST", Name: "n", In: "body", Line: 5, Ends: []string{"filter intval"}},
+		}},
+		{File: "test.php", Endpoint: "test.php", Params: []SurfaceParam{
+			{Source: "$_GET", Name: "id", In: "query", Line: 2, Flows: []string{"assign $id"}, Ends: []string{"sink echo"}},
+		}},
+	}
+	if got := Surface.Endpoints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("endpoints %+v, want %+v", got, want)
+	}
+}
+
+func TestSurfaceTouchOutsideCalls(t *testing.T) {
+	SurfaceMode, Surface = true, &Inventory{reads: make(map[string]*Read)}
+	defer func() { SurfaceMode = false }()
+
+	root, err := parseutil.ParseFile([]byte("<?php\n$_GET['id'];"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := root.Stmts[0].(*ast.StmtExpression).Expr.(*ast.ExprArrayDimFetch).Var
+	// nothing is on the call stack around the read to find its key in
+	a := NewAnalyzer("test.php", "data.yaml")
+	a.Touch(Taint{Name: "$_GET", Type: "surface"}, Item{Name: "echo", Type: "sink"}, read, false)
+	if endpoints := Surface.Endpoints(); len(endpoints) != 1 || endpoints[0].Params[0].Source != "$_GET" {
+		t.Errorf("endpoints %+v, want the read of $_GET", endpoints)
+	}
+}
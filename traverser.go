@@ -748,12 +748,19 @@ func (t *Traverser) ExprFunctionCall(n *ast.ExprFunctionCall) {
 	name := ""
 	funcName, ok := n.Function.(*ast.Name)
 	if !ok {
+		t.DynamicCall(n, n.Function, func() {}, n.Args)
 		return
 	}
 	for _, v := range funcName.Parts {
 		name += string(v.(*ast.NamePart).Value)
 	}
 
+	t.Call(n, name, func() { t.Traverse(n.Function) }, n.Args)
+}
+
+// Call classifies a call by name and traverses its arguments accordingly,
+// callee traverses whatever expression names the function
+func (t *Traverser) Call(n ast.Vertex, name string, callee func(), nargs []ast.Vertex) {
 	var args []int
 	callType := "filter"
 
@@ -765,17 +772,34 @@ func (t *Traverser) ExprFunctionCall(n *ast.ExprFunctionCall) {
 		}
 	}
 
+	// vulns may watch different arguments of the same function
 	for _, vuln := range t.v.Data {
 		for str, arg := range vuln.Args {
 			if name == str {
 				callType = "argument"
-				args = arg
+				args = append(args, arg...)
 			}
 		}
 	}
 
+	// a write can also be a sink, file_put_contents is both
+	var write []int
+	for _, vuln := range t.v.Data {
+		if w, ok := vuln.Writes[name]; ok && len(w) == 2 {
+			write = w
+		}
+	}
+	key, stores := "", false
+	if write != nil {
+		key, stores = StorageArg(name, n, write[0])
+	}
+	if stores && callType != "argument" {
+		callType = "store"
+	}
+
+	// annotated sinks and sanitizers are taken at their word rather than followed
 	vert, ok := t.ResolvedNames[name]
-	if ok {
+	if ok && !t.v.Declared[name] {
 		callType = "custom"
 	}
 
@@ -785,8 +809,8 @@ func (t *Traverser) ExprFunctionCall(n *ast.ExprFunctionCall) {
 
 		n.Accept(t.v)
 
-		t.Traverse(n.Function)
-		for _, nn := range n.Args {
+		callee()
+		for _, nn := range nargs {
 			nn.Accept(t)
 		}
 
@@ -796,9 +820,8 @@ func (t *Traverser) ExprFunctionCall(n *ast.ExprFunctionCall) {
 
 		n.Accept(t.v)
 
-		t.Traverse(n.Function)
-
-		for _, nn := range n.Args {
+		callee()
+		for _, nn := range nargs {
 			nn.Accept(t)
 		}
 
@@ -806,19 +829,22 @@ func (t *Traverser) ExprFunctionCall(n *ast.ExprFunctionCall) {
 	case "custom":
 		n.Accept(t.v)
 
-		t.Traverse(n.Function)
+		callee()
 
-		for i, nn := range n.Args {
-			function, ok := vert.(*ast.StmtFunction)
-			if !ok {
-				nn.Accept(t)
-				continue
-			}
-			if len(function.Params) <= i {
+		var params []ast.Vertex
+		switch function := vert.(type) {
+		case *ast.StmtFunction:
+			params = function.Params
+		case *ast.StmtClassMethod:
+			params = function.Params
+		}
+
+		for i, nn := range nargs {
+			if len(params) <= i {
 				nn.Accept(t)
 				continue
 			}
-			param, ok := function.Params[i].(*ast.Parameter)
+			param, ok := params[i].(*ast.Parameter)
 			if !ok {
 				nn.Accept(t)
 				continue
@@ -841,28 +867,90 @@ func (t *Traverser) ExprFunctionCall(n *ast.ExprFunctionCall) {
 			_ = t.v.Pop()
 		}
 
+	case "store":
+		n.Accept(t.v)
+
+		callee()
+
+		for i, nn := range nargs {
+			if i == write[1] {
+				t.v.Push(Item{Name: name, Type: "store", Key: StorageKey(name, key), Vertex: n})
+
+				nn.Accept(t)
+
+				_ = t.v.Pop()
+			} else {
+				nn.Accept(t)
+			}
+		}
+
 	case "argument":
 		n.Accept(t.v)
 
-		t.Traverse(n.Function)
+		callee()
 
-		for i, nn := range n.Args {
+		for i, nn := range nargs {
+			sink := false
 			for _, j := range args {
 				if i == j {
-					// sink arg
-					t.v.Push(Item{Name: name, Type: "sink", Vertex: n})
+					sink = true
+				}
+			}
 
-					nn.Accept(t)
+			// the sink is met first, the store ends the trace
+			store := stores && i == write[1]
+			if store {
+				t.v.Push(Item{Name: name, Type: "store", Key: StorageKey(name, key), Vertex: n})
+			}
+			if sink {
+				t.v.Push(Item{Name: name, Type: "sink", Vertex: n})
+			}
 
-					_ = t.v.Pop()
-				} else {
-					nn.Accept(t)
-				}
+			nn.Accept(t)
+
+			if sink {
+				_ = t.v.Pop()
+			}
+			if store {
+				_ = t.v.Pop()
 			}
 		}
 	}
 }
 
+// DynamicCall handles $fn(), $obj->$method() and friends,
+// a tainted callee is an rce sink in itself, and the arguments are
+// traced once for every name the callee is known to hold
+func (t *Traverser) DynamicCall(n ast.Vertex, callee ast.Vertex, rest func(), nargs []ast.Vertex) {
+	t.v.Push(Item{Name: "dynamic_call", Type: "sink", Vertex: n})
+	t.Traverse(callee)
+	_ = t.v.Pop()
+
+	names := t.v.ResolveCallee(callee)
+	if len(names) == 0 {
+		// nothing known about the callee, the arguments pass through it
+		t.v.Push(Item{Name: "dynamic_call", Type: "call", Vertex: n})
+
+		n.Accept(t.v)
+
+		rest()
+		for _, nn := range nargs {
+			nn.Accept(t)
+		}
+
+		_ = t.v.Pop()
+		return
+	}
+
+	for i, name := range names {
+		if i == 0 {
+			t.Call(n, name, rest, nargs)
+		} else {
+			t.Call(n, name, func() {}, nargs)
+		}
+	}
+}
+
 func (t *Traverser) ExprInclude(n *ast.ExprInclude) {
 	n.Accept(t.v)
 
@@ -904,120 +992,16 @@ func (t *Traverser) ExprList(n *ast.ExprList) {
 func (t *Traverser) ExprMethodCall(n *ast.ExprMethodCall) {
 	id, ok := n.Method.(*ast.Identifier)
 	if !ok {
+		t.DynamicCall(n, n.Method, func() { t.Traverse(n.Var) }, n.Args)
 		return
 	}
 
 	name := string(id.Value)
 
-	var args []int
-	callType := "filter"
-
-	for _, vuln := range t.v.Data {
-		for _, sink := range vuln.Sinks {
-			if name == sink {
-				callType = "sink"
-			}
-		}
-	}
-
-	for _, vuln := range t.v.Data {
-		for str, arg := range vuln.Args {
-			if name == str {
-				callType = "argument"
-				args = arg
-			}
-		}
-	}
-
-	vert, ok := t.ResolvedNames[name]
-	if ok {
-		callType = "custom"
-	}
-
-	switch callType {
-	case "filter":
-		t.v.Push(Item{Name: name, Type: "filter", Vertex: n})
-
-		n.Accept(t.v)
-
-		t.Traverse(n.Var)
-		t.Traverse(n.Method)
-		for _, nn := range n.Args {
-			nn.Accept(t)
-		}
-
-		_ = t.v.Pop()
-	case "sink":
-		t.v.Push(Item{Name: name, Type: "sink", Vertex: n})
-
-		n.Accept(t.v)
-
-		t.Traverse(n.Var)
-		t.Traverse(n.Method)
-		for _, nn := range n.Args {
-			nn.Accept(t)
-		}
-
-		_ = t.v.Pop()
-	case "custom":
-		n.Accept(t.v)
-
+	t.Call(n, name, func() {
 		t.Traverse(n.Var)
 		t.Traverse(n.Method)
-		for i, nn := range n.Args {
-			function, ok := vert.(*ast.StmtClassMethod)
-			if !ok {
-				nn.Accept(t)
-				continue
-			}
-			if len(function.Params) <= i {
-				nn.Accept(t)
-				continue
-			}
-			param, ok := function.Params[i].(*ast.Parameter)
-			if !ok {
-				nn.Accept(t)
-				continue
-			}
-			variable, ok := param.Var.(*ast.ExprVariable)
-			if !ok {
-				nn.Accept(t)
-				continue
-			}
-			id, ok := variable.Name.(*ast.Identifier)
-			if !ok {
-				nn.Accept(t)
-				continue
-			}
-
-			t.v.Push(Item{Name: string(id.Value), Type: "assign", Scope: Context{Class: "*", Block: name}, Vertex: n})
-
-			nn.Accept(t)
-
-			_ = t.v.Pop()
-		}
-
-	case "argument":
-		n.Accept(t.v)
-
-		t.Traverse(n.Method)
-		t.Traverse(n.Var)
-
-		for i, nn := range n.Args {
-			for _, j := range args {
-				if i == j {
-					// sink arg
-					t.v.Push(Item{Name: name, Type: "sink", Vertex: n})
-
-					nn.Accept(t)
-
-					_ = t.v.Pop()
-				} else {
-					nn.Accept(t)
-				}
-			}
-		}
-	}
+	}, n.Args)
 }
 
 func (t *Traverser) ExprNullsafeMethodCall(n *ast.ExprNullsafeMethodCall) {
@@ -1108,6 +1092,11 @@ func (t *Traverser) ExprShellExec(n *ast.ExprShellExec) {
 }
 
 func (t *Traverser) ExprStaticCall(n *ast.ExprStaticCall) {
+	if _, ok := n.Call.(*ast.Identifier); !ok {
+		t.DynamicCall(n, n.Call, func() { t.Traverse(n.Class) }, n.Args)
+		return
+	}
+
 	n.Accept(t.v)
 
 	t.Traverse(n.Class)
@@ -1148,6 +1137,12 @@ func (t *Traverser) ExprUnaryPlus(n *ast.ExprUnaryPlus) {
 }
 
 func (t *Traverser) ExprVariable(n *ast.ExprVariable) {
+	// $$name, the value comes from wherever $name points
+	if _, ok := n.Name.(*ast.Identifier); !ok {
+		t.v.Push(Item{Name: "variable variable", Type: "doubt", Vertex: n})
+		defer t.v.Pop()
+	}
+
 	n.Accept(t.v)
 
 	t.Traverse(n.Name)
@@ -1174,6 +1169,11 @@ func (t *Traverser) ExprAssign(n *ast.ExprAssign) {
 			break
 		}
 
+		// remember string literals for resolving dynamic calls
+		if str, ok := n.Expr.(*ast.ScalarString); ok {
+			t.v.AddConstant(Constant{Name: string(name.Value), Value: Unquote(string(str.Value)), Scope: t.v.CurrentContext})
+		}
+
 		t.v.Push(Item{Name: string(name.Value), Type: "assign", Scope: t.v.CurrentContext, Vertex: n})
 		defer t.v.Pop()
 	case *ast.ExprPropertyFetch:
@@ -1184,6 +1184,12 @@ func (t *Traverser) ExprAssign(n *ast.ExprAssign) {
 
 		t.v.Push(Item{Name: string(name.Value), Type: "assign", Scope: Context{Block: "*", Class: t.v.CurrentContext.Class}, Vertex: n})
 		defer t.v.Pop()
+	case *ast.ExprArrayDimFetch:
+		// $_SESSION['key'] = ..., stored for the requests after this one
+		if key, ok := SessionKey(variable); ok {
+			t.v.Push(Item{Name: "$_SESSION", Type: "store", Key: StorageKey("$_SESSION", key), Vertex: n})
+			defer t.v.Pop()
+		}
 	}
 
 	n.Accept(t.v)
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VKCOM/noverify/src/php/parseutil"
+	"github.com/VKCOM/php-parser/pkg/ast"
+)
+
+// analyze runs the analyzer over a PHP source on its own, as a worker would,
+// and returns what it reported
+func analyze(t *testing.T, source string) []Result {
+	t.Helper()
+	root := parse(t, source)
+	return collect(func() {
+		tr := NewTraverser(NewAnalyzer("test.php", "data.yaml"))
+		for i := 0; i < 10; i++ {
+			tr.Traverse(root)
+		}
+	})
+}
+
+func parse(t *testing.T, source string) *ast.Root {
+	t.Helper()
+	root, err := parseutil.ParseFile([]byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// collect returns the results sent while run runs
+func collect(run func()) []Result {
+	results := make(chan Result)
+	Results = results
+	done := make(chan []Result)
+	go func() {
+		var reported []Result
+		for result := range results {
+			reported = append(reported, result)
+		}
+		done <- reported
+	}()
+
+	run()
+	close(results)
+	return <-done
+}
+
+// reported finds a result of a type with its sink on a line
+func reported(results []Result, typ string, line int) (Result, bool) {
+	for _, r := range results {
+		if r.Type == typ && r.Vertex.GetPosition().StartLine == line {
+			return r, true
+		}
+	}
+	return Result{}, false
+}
+
+func TestDynamicCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		line   int
+		want   bool
+	}{
+		{"tainted function name", "<?php\n$_GET['f']($_GET['a']);", 2, true},
+		{"sink in the arguments of a variable function", "<?php\n$fn(system($_GET['x']));", 2, true},
+		{"sink in the arguments of a variable method", "<?php\n$obj->$method(system($_GET['x']));", 2, true},
+		{"sink in the arguments of a variable static method", "<?php\nFoo::$m(system($_GET['x']));", 2, true},
+		{"tainted static method name", "<?php\nFoo::{$_GET['m']}();", 2, true},
+		{"method of a new object", "<?php\n(new Foo)->bar(system($_GET['x']));", 2, true},
+		{"function name resolved to a sink", "<?php\n$fn = 'system';\n$fn($_GET['x']);", 3, true},
+		{"function name resolved to a harmless function", "<?php\n$fn = 'intval';\n$fn($_GET['x']);", 3, false},
+	}
+	for _, test := range tests {
+		if _, got := reported(analyze(t, test.source), "rce", test.line); got != test.want {
+			t.Errorf("%s: reported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDynamicCallPath(t *testing.T) {
+	r, ok := reported(analyze(t, "<?php\n$o->$m(system($_GET['x']));"), "rce", 2)
+	if !ok {
+		t.Fatal("no rce finding")
+	}
+	// the taint passes through the call, it isn't filtered by it
+	if strings.Contains(r.Stack, "[filter]") || !strings.Contains(r.Stack, "[call] dynamic_call") {
+		t.Errorf("path %q, want the dynamic call shown as a call", r.Stack)
+	}
+}